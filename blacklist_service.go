@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"soliveboa/youtuber/v2/dao"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// blacklistRefreshInterval - how often the running service reads the blacklist again,
+// so the channels added by "blacklist add" are skipped without a restart
+const blacklistRefreshInterval = time.Minute
+
+var (
+	blacklistMu sync.RWMutex
+	blacklist   dao.BlacklistSet
+)
+
+// loadBlacklist reads the blacklisted channels and replaces the ones in use.
+// When the read fails the previous blacklist is kept
+func loadBlacklist() {
+
+	set, err := dao.LoadBlacklist(repositories.Blacklist)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err": err.Error(),
+		}).Error("Error to load the blacklist, the previous one is kept")
+
+		return
+	}
+
+	blacklistMu.Lock()
+	changed := blacklist == nil || len(set) != len(blacklist)
+	blacklist = set
+	blacklistMu.Unlock()
+
+	if changed {
+		logrus.WithFields(logrus.Fields{
+			"total": len(set),
+		}).Info("[~] Blacklist loaded")
+	}
+}
+
+// refreshBlacklist reloads the blacklist until the context is done
+func refreshBlacklist(ctx context.Context) {
+
+	ticker := time.NewTicker(blacklistRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			loadBlacklist()
+		}
+	}
+}
+
+// isBlacklisted tells if the channel is in the blacklist in use
+func isBlacklisted(channelID string) bool {
+
+	blacklistMu.RLock()
+	defer blacklistMu.RUnlock()
+
+	return blacklist.Contains(channelID)
+}
+
+// skipBlacklisted returns true when the video belongs to a blacklisted channel.
+// The video skipped is recorded so we can see what was dropped
+func skipBlacklisted(ctx context.Context, videoID string, channelID string, source string) bool {
+
+	if !isBlacklisted(channelID) {
+		return false
+	}
+
+	repositories.Skipped.Insert(dao.SkippedVideo{
+		VideoID:   videoID,
+		ChannelID: channelID,
+		Reason:    dao.SkippedReasonBlacklist,
+		Source:    source,
+	})
+
+	logFrom(ctx).WithFields(logrus.Fields{
+		"video_id":   videoID,
+		"channel_id": channelID,
+		"source":     source,
+	}).Info("Video skipped because the channel is blacklisted")
+
+	return true
+}
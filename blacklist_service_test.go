@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"soliveboa/youtuber/v2/dao"
+	"testing"
+)
+
+// brokenBlacklist fails to read the blacklist, like a database gone away
+type brokenBlacklist struct {
+	dao.BlacklistRepository
+}
+
+func (b brokenBlacklist) Index() ([]dao.Blacklist, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestLoadBlacklistKeepsThePreviousOne(t *testing.T) {
+
+	useMemoryStorage(t)
+
+	repositories.Blacklist.Insert(dao.Blacklist{ChannelID: "UC-blocked"})
+	loadBlacklist()
+
+	if !isBlacklisted("UC-blocked") {
+		t.Fatal("isBlacklisted = false after the load")
+	}
+
+	repositories.Blacklist = brokenBlacklist{repositories.Blacklist}
+	loadBlacklist()
+
+	if !isBlacklisted("UC-blocked") {
+		t.Error("isBlacklisted = false after a failed reload, want the previous blacklist")
+	}
+}
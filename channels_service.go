@@ -4,15 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"flag"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"soliveboa/youtuber/v2/dao"
 	"soliveboa/youtuber/v2/entities"
 	_errors "soliveboa/youtuber/v2/errors"
-	"soliveboa/youtuber/v2/rabbit"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -29,23 +27,28 @@ type ChannelWebList struct {
 }
 
 func NewChannelWebListService() ChannelWebListService {
-
-	webserver = entities.GetWebServer().BaseURL
-	endpoints = entities.GetWebServerEndpoints()
-
 	return ChannelWebListService{}
 }
 
 // UpdateChannelsFromWebServer method
 // Get the channel from the web server and save them into local database
-func (s ChannelWebListService) UpdateChannelsFromWebServer() error {
+func (s ChannelWebListService) UpdateChannelsFromWebServer(ctx context.Context) error {
+
+	// read on each run, the jobs run at the same time and the settings may have been reloaded
+	endpoint := entities.GetWebServer().BaseURL + entities.GetWebServerEndpoints().Channels
 
-	response, err := http.Get(webserver + endpoints.Channels)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
+		return err
+	}
+
+	response, err := http.DefaultClient.Do(request)
+
+	if err != nil {
+		logFrom(ctx).WithFields(logrus.Fields{
 			"err":      err.Error(),
-			"endpoint": webserver + endpoints.Channels,
+			"endpoint": endpoint,
 		}).Error("Error to GET data from the api")
 
 		return err
@@ -57,9 +60,9 @@ func (s ChannelWebListService) UpdateChannelsFromWebServer() error {
 	contents, err := ioutil.ReadAll(response.Body)
 
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
+		logFrom(ctx).WithFields(logrus.Fields{
 			"err":      err.Error(),
-			"endpoint": webserver + endpoints.Channels,
+			"endpoint": endpoint,
 		}).Error("Error to ready body from the api")
 
 		return err
@@ -71,77 +74,104 @@ func (s ChannelWebListService) UpdateChannelsFromWebServer() error {
 
 	if err != nil {
 
-		logrus.WithFields(logrus.Fields{
+		logFrom(ctx).WithFields(logrus.Fields{
 			"err":      err.Error(),
-			"endpoint": webserver + endpoints.Channels,
+			"endpoint": endpoint,
 		}).Error("Error to unmarshall body from api data")
 
 		return err
 	}
 
+	// an empty answer is more likely a problem on the web server than no channels at all,
+	// syncing it would remove all the channels with their sync state
 	if len(data) <= 0 {
-		logrus.WithFields(logrus.Fields{
-			"endpoint": webserver + endpoints.Channels,
-		}).Info("No data received from the web server")
-	}
+		logFrom(ctx).WithFields(logrus.Fields{
+			"endpoint": endpoint,
+		}).Warning("No data received from the web server, the channels are kept as they are")
 
-	// create the service
-	channelService := dao.NewChannelService()
-	// trucate the data from database
-	channelService.Truncate()
+		return nil
+	}
 
-	// insert the new data
-	c := 0
+	channels := []dao.Channel{}
 	for key := range data {
+		channels = append(channels, dao.Channel{ChannelID: data[key].ChannelID, FullSynced: data[key].FullSynced})
+	}
 
-		insert := dao.Channel{ChannelID: data[key].ChannelID, FullSynced: data[key].FullSynced}
-		err = channelService.Insert(insert)
-
-		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"err":       err.Error(),
-				"channelId": data[key].ChannelID,
-				"endpoint":  webserver + endpoints.Channels,
-			}).Error("Error to insert channel")
-
-			continue
-		}
+	// keep the local channels the same as the web server
+	c, err := repositories.Channels.Sync(channels)
 
-		c++
+	if err != nil {
+		logFrom(ctx).WithFields(logrus.Fields{
+			"err":      err.Error(),
+			"endpoint": endpoint,
+		}).Error("Error to sync channels")
 	}
 
-	logrus.Info(strconv.Itoa(c) + " channels inserted...")
+	logFrom(ctx).Info(strconv.Itoa(c) + " channels inserted...")
 	return nil
 }
 
-var pageToken = ""
-
 // SearcSearchVideosByChannels method
-func (s ChannelWebListService) SearchVideosByChannels() error {
+func (s ChannelWebListService) SearchVideosByChannels(ctx context.Context) error {
 
 	// obtem todos os canais do banco de dados
-	channels := dao.NewChannelService().Index()
+	channels := repositories.Channels.Index()
 
 	if len(channels) <= 0 {
 		return errors.New("There are no channels registered in the dabase")
 	}
 
+	uploadsMode := entities.GetChannelCrawlParameters().UploadsMode()
+
+	if uploadsMode {
+		err := callWithKey(ctx, dao.QuotaCostChannels, func(key string) error {
+			return s.ResolveUploadsPlaylists(ctx, key, channels)
+		})
+
+		if err == dao.ErrNoKeyAvailable {
+			return err
+		}
+
+		_errors.HandleError("Error to resolve the uploads playlists", err, false)
+	}
+
 	// executa looping por cada canal no endpoint search para obter os videos upcomings naquele canal
-	for key := range channels {
-		err := NewChannelWebListService().RetrieveVideos(channels[key], pageToken)
-		_errors.HandleError("Error to retrieve data from playlist", err, false)
+	for i := range channels {
 
-		is403, _ := _errors.VerifyError403(err)
-		if is403 {
-			// remove the key from the array
-			_, err, keys := dao.GetNewKey(authKeys, true)
-			authKeys = keys
+		// stop before the next channel when the service is shutting down
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-			if err != nil {
-				break
-			}
+		channel := channels[i]
 
+		// the whole channel is blacklisted, no need to spend quota on it
+		if isBlacklisted(channel.ChannelID) {
+			logFrom(ctx).WithFields(logrus.Fields{
+				"channel_id": channel.ChannelID,
+			}).Info("Channel skipped because it is blacklisted")
+
+			continue
 		}
+
+		var err error
+
+		if uploadsMode && channel.FullSynced && channel.UploadsPlaylistID != "" {
+			err = callWithKey(ctx, dao.QuotaCostPlaylistItems, func(key string) error {
+				return s.RetrieveUploads(ctx, key, channel)
+			})
+		} else {
+			err = callWithKey(ctx, dao.QuotaCostSearch, func(key string) error {
+				return NewChannelWebListService().RetrieveVideos(ctx, key, channel)
+			})
+		}
+
+		if err == dao.ErrNoKeyAvailable {
+			failOnError(err, "error to get key from list")
+			break
+		}
+
+		_errors.HandleError("Error to retrieve data from playlist", err, false)
 	}
 
 	// verifica se o canal é full sync ou não para definir data de published after
@@ -152,166 +182,248 @@ func (s ChannelWebListService) SearchVideosByChannels() error {
 }
 
 // RetrieveVideos method
-func (s ChannelWebListService) RetrieveVideos(channel dao.Channel, nextToken string) error {
+// Searches the videos inside the channel. Channels not fully synced yet get their whole history,
+// the others only the videos published inside the incremental window
+func (s ChannelWebListService) RetrieveVideos(ctx context.Context, key string, channel dao.Channel) error {
 
 	if channel.ID == 0 {
 		return nil
 	}
 
-	// key a key to use
-	key, err, _ := dao.GetNewKey(authKeys, false)
+	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(key))
 
 	if err != nil {
-		failOnError(err, "error to get key from list")
+		return err
 	}
 
-	flag.Parse()
-
-	ctx := context.Background()
+	if channel.FullSynced {
+		return s.retrieveRecentVideos(ctx, youtubeService, key, channel)
+	}
 
-	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(key))
+	return s.backfillVideos(ctx, youtubeService, key, channel)
+}
 
-	if err != nil {
-		return err
-	}
+// retrieveRecentVideos searches the channel videos published inside the incremental window
+func (s ChannelWebListService) retrieveRecentVideos(ctx context.Context, youtubeService *youtube.Service, key string, channel dao.Channel) error {
 
 	params := entities.GetParametersList()
+	window := entities.GetChannelCrawlParameters().IncrementalWindow()
 
 	call := youtubeService.Search.List(params.Part)
+	call.ChannelId(channel.ChannelID)
 	call.Type(params.VideoType)
 	call.MaxResults(params.MaxResults)
 	call.EventType(params.EventType)
 	call.Order(params.Order)
+	call.PublishedAfter(time.Now().Add(-window).Format(time.RFC3339))
 	call.Fields("prevPageToken,nextPageToken,items(id(videoId),snippet(channelId))")
 
-	if nextToken != "" {
-		call.PageToken(nextToken)
-	}
+	// resume from the page where the last run has stopped
+	crawlKey := "channel:" + channel.ChannelID
+	call.PageToken(loadCheckpoint(crawlKey))
 
-	if channel.FullSynced {
-		publishedAfter := time.Now().AddDate(0, 0, -1).Format(time.RFC3339)
-		call.PublishedAfter(publishedAfter)
-	}
+	// empty pages in a row, each crawl has its own count
+	nulls := 0
 
-	err = call.Pages(ctx, addChannelPagedResult)
+	err := call.Pages(ctx, func(values *youtube.SearchListResponse) error {
+		keyPool.Charge(key, dao.QuotaCostSearch)
 
-	if err != nil {
-		return err
-	}
+		err := addChannelPagedResult(ctx, values, &nulls)
 
-	pageToken = ""
-	return nil
+		if err != nil {
+			return err
+		}
+
+		saveCheckpoint(crawlKey, values.NextPageToken)
+		return nil
+	})
+
+	return checkpointError(crawlKey, err)
 }
 
-var countChannelNullResults = 0
+// backfillVideos searches the whole history of the channel, newest first.
+// The search api stops returning pages after a few hundred results, so the history is read
+// in windows: each window starts before the oldest video found by the previous one
+func (s ChannelWebListService) backfillVideos(ctx context.Context, youtubeService *youtube.Service, key string, channel dao.Channel) error {
 
-func addChannelPagedResult(values *youtube.SearchListResponse) error {
+	params := entities.GetParametersList()
+	tokenRecovery := repositories.Checkpoints
 
-	totalAlreadyProcessed++
+	crawlKey := "channel:" + channel.ChannelID
+	windowKey := "channel-backfill:" + channel.ChannelID
 
-	if len(values.Items) < 0 {
-		logrus.Warn("[!] The message receive from API doesnt't have any item")
-		return nil
+	// the window where the last run has stopped
+	publishedBefore := ""
+	if tkr, err := tokenRecovery.Checkpoint(windowKey); err == nil {
+		publishedBefore = tkr.NextToken
 	}
 
-	pageToken = values.NextPageToken
+	logFrom(ctx).WithFields(logrus.Fields{
+		"channel_id":      channel.ChannelID,
+		"publishedBefore": publishedBefore,
+	}).Info("[<] Started channel history backfill")
 
-	// define id array
-	var id []string
+	for {
 
-	// looping through the items
-	for key := range values.Items {
-
-		vid := values.Items[key].Id.VideoId
+		call := youtubeService.Search.List(params.Part)
+		call.ChannelId(channel.ChannelID)
+		call.Type(params.VideoType)
+		call.MaxResults(params.MaxResults)
+		call.Order("date")
+		call.Fields("prevPageToken,nextPageToken,items(id(videoId),snippet(channelId,publishedAt))")
 
-		if vid == "" {
-			logrus.Warning("[!] video item is empty")
-			continue
+		if publishedBefore != "" {
+			call.PublishedBefore(publishedBefore)
 		}
 
-		id = append(id, vid)
-	}
+		call.PageToken(loadCheckpoint(crawlKey))
 
-	// define the list
-	listID := &ListOfIdsFromSearch{Source: "channel", IDs: id}
+		oldest := publishedBefore
+		total := 0
+		nulls := 0
 
-	err := handleNullChannelsResult(len(listID.IDs))
+		err := call.Pages(ctx, func(values *youtube.SearchListResponse) error {
+			keyPool.Charge(key, dao.QuotaCostSearch)
 
-	if err != nil {
-		return err
+			for _, item := range values.Items {
+				if item.Snippet != nil && olderThan(item.Snippet.PublishedAt, oldest) {
+					oldest = item.Snippet.PublishedAt
+				}
+			}
+
+			total += len(values.Items)
+
+			err := addChannelPagedResult(ctx, values, &nulls)
+
+			if err != nil {
+				return err
+			}
+
+			saveCheckpoint(crawlKey, values.NextPageToken)
+			return nil
+		})
+
+		// only empty pages from here on (AP001): the history is complete
+		if err == errNullPages {
+			clearCheckpoint(crawlKey)
+			break
+		}
+
+		if err != nil {
+			return checkpointError(crawlKey, err)
+		}
+
+		// nothing older than the window has been found: the history is complete
+		if total == 0 || oldest == publishedBefore {
+			break
+		}
+
+		publishedBefore = oldest
+		tokenRecovery.Save(windowKey, publishedBefore)
 	}
 
-	countChannelNullResults = 0
+	tokenRecovery.Clear(windowKey)
 
-	// send the message to rabbit
-	err = sendChannelMessage(listID)
+	err := repositories.Channels.UpdateFullSynced(channel.ChannelID, true)
 
 	if err != nil {
 		return err
 	}
 
+	logFrom(ctx).WithFields(logrus.Fields{
+		"channel_id": channel.ChannelID,
+	}).Info("[>] Finished channel history backfill")
+
 	return nil
 }
 
-func sendChannelMessage(a *ListOfIdsFromSearch) error {
+// olderThan compares two RFC3339 dates. An empty reference means there is nothing to compare yet
+func olderThan(publishedAt string, reference string) bool {
 
-	v, err := json.Marshal(a)
+	p, err := time.Parse(time.RFC3339, publishedAt)
 
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Error("Error to serialize video list")
+		return false
+	}
 
-		return err
+	if reference == "" {
+		return true
 	}
 
-	service := rabbit.New()
-	conn, err := service.Connect()
+	r, err := time.Parse(time.RFC3339, reference)
 
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Error("Error to connect to the broker")
+		return true
+	}
 
-		return err
+	return p.Before(r)
+}
+
+func addChannelPagedResult(ctx context.Context, values *youtube.SearchListResponse, nulls *int) error {
+
+	atomic.AddInt64(&totalAlreadyProcessed, 1)
+
+	if len(values.Items) < 0 {
+		logFrom(ctx).Warn("[!] The message receive from API doesnt't have any item")
+		return nil
 	}
 
-	exchange, err := conn.Exchange("to.youtuber.videos")
+	// define id array
+	var id []string
 
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error":    err,
-			"exchange": "to.youtuber.videos",
-		}).Error("Error to declare exchange")
+	// looping through the items
+	for key := range values.Items {
 
-		return err
+		vid := values.Items[key].Id.VideoId
+
+		if vid == "" {
+			logFrom(ctx).Warning("[!] video item is empty")
+			continue
+		}
+
+		if values.Items[key].Snippet != nil && skipBlacklisted(ctx, vid, values.Items[key].Snippet.ChannelId, "channel") {
+			continue
+		}
+
+		id = append(id, vid)
 	}
 
-	_, err = exchange.Publish(v)
+	// define the list
+	listID := &ListOfIdsFromSearch{Source: "channel", IDs: id}
 
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Error("Error to publish the message")
+	err := handleNullChannelsResult(ctx, len(listID.IDs), nulls)
 
+	if err != nil || len(listID.IDs) <= 0 {
 		return err
 	}
 
-	logrus.Info("Page has been sent to queue")
+	// send the message to rabbit
+	err = sendResponse(ctx, listID)
+
+	if err != nil {
+		return err
+	}
 
 	return nil
-
 }
 
-func handleNullChannelsResult(totalResults int) error {
+func handleNullChannelsResult(ctx context.Context, totalResults int, countChannelNullResults *int) error {
+
+	// reset count null var because the last one was not empty
+	if totalResults > 0 {
+		*countChannelNullResults = 0
+		return nil
+	}
 
 	// increment
-	countChannelNullResults++
-	fmt.Printf("null count: %v\n", countChannelNullResults)
+	*countChannelNullResults++
+	logFrom(ctx).WithFields(logrus.Fields{
+		"nulls": *countChannelNullResults,
+	}).Debug("Empty page from the api")
 
 	// is more than 5
-	if totalNull >= 5 {
-		return errors.New("AP001 - Reached null api response")
+	if *countChannelNullResults >= 5 {
+		return errNullPages
 	}
 
 	return nil
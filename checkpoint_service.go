@@ -0,0 +1,65 @@
+package main
+
+import (
+	_errors "soliveboa/youtuber/v2/errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// checkpointMaxAge - page tokens older than that are not trusted anymore, the crawl starts over
+const checkpointMaxAge = 24 * time.Hour
+
+// loadCheckpoint returns the page token where the crawl has stopped, or empty to start from the first page
+func loadCheckpoint(crawlKey string) string {
+
+	tkr, err := repositories.Checkpoints.Checkpoint(crawlKey)
+
+	if err != nil || tkr.NextToken == "" {
+		return ""
+	}
+
+	if time.Since(tkr.UpdatedAt) > checkpointMaxAge {
+		clearCheckpoint(crawlKey)
+		return ""
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"crawl": crawlKey,
+		"token": tkr.NextToken,
+	}).Info("[~] Resuming crawl from checkpoint")
+
+	return tkr.NextToken
+}
+
+// saveCheckpoint stores the next page of the crawl. The last page clears the checkpoint
+func saveCheckpoint(crawlKey string, nextToken string) {
+
+	if nextToken == "" {
+		clearCheckpoint(crawlKey)
+		return
+	}
+
+	repositories.Checkpoints.Save(crawlKey, nextToken)
+}
+
+func clearCheckpoint(crawlKey string) {
+	repositories.Checkpoints.Clear(crawlKey)
+}
+
+// checkpointError clears the checkpoint when the page token saved is not accepted anymore,
+// or the crawl has reached the null pages (AP001), so the next run starts from the first page
+func checkpointError(crawlKey string, err error) error {
+
+	ytErr := _errors.Classify(err)
+
+	if ytErr == nil {
+		return nil
+	}
+
+	if ytErr.Kind == _errors.KindInvalidPageToken || err == errNullPages {
+		clearCheckpoint(crawlKey)
+	}
+
+	return err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestCheckpointError(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		err       error
+		wantToken string
+	}{
+		{"no error", nil, "CAUQAA"},
+		{"invalid page token", apiError(http.StatusBadRequest, "invalidPageToken"), ""},
+		{"only empty pages", errNullPages, ""},
+		{"quota exceeded", apiError(http.StatusForbidden, "quotaExceeded"), "CAUQAA"},
+		{"network", errors.New("connection reset"), "CAUQAA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			useMemoryStorage(t)
+			saveCheckpoint("playlist:PL1", "CAUQAA")
+
+			if got := checkpointError("playlist:PL1", tt.err); got != tt.err {
+				t.Errorf("checkpointError() = %v, want %v", got, tt.err)
+			}
+
+			if got := loadCheckpoint("playlist:PL1"); got != tt.wantToken {
+				t.Errorf("checkpoint = %q, want %q", got, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestSaveCheckpoint(t *testing.T) {
+
+	useMemoryStorage(t)
+
+	saveCheckpoint("category:10", "CAUQAA")
+	saveCheckpoint("category:20", "CAoQAA")
+
+	if got := loadCheckpoint("category:10"); got != "CAUQAA" {
+		t.Errorf("checkpoint = %q, want the page saved", got)
+	}
+
+	// the last page has no next token, the next run starts from the first page
+	saveCheckpoint("category:10", "")
+
+	if got := loadCheckpoint("category:10"); got != "" {
+		t.Errorf("checkpoint = %q, want it cleared by the last page", got)
+	}
+
+	if got := loadCheckpoint("category:20"); got != "CAoQAA" {
+		t.Errorf("checkpoint = %q, each crawl must keep its own checkpoint", got)
+	}
+}
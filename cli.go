@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"soliveboa/youtuber/v2/dao"
+	"soliveboa/youtuber/v2/entities"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+// appName is the name of the binary in the help texts
+const appName = "youtuber"
+
+// errUsage - the command line is wrong, the usage has already been printed
+var errUsage = errors.New("invalid usage")
+
+// command - a subcommand of the cli. Commands with subcommands don't have run
+type command struct {
+	name        string
+	summary     string
+	run         func(args []string) error
+	subcommands []command
+}
+
+// commands available in the cli
+var commands = []command{
+	{
+		name:    "collect",
+		summary: "crawls youtube and publishes the videos found",
+		subcommands: []command{
+			{name: "playlists", summary: "crawls the playlists", run: runCollectPlaylists},
+			{name: "channels", summary: "crawls the channels from the web server", run: runCollectChannels},
+			{name: "categories", summary: "searches the videos by category", run: runCollectCategories},
+			{name: "locations", summary: "searches the upcoming videos around each location", run: runCollectLocations},
+			{name: "queries", summary: "searches the videos by the keyword queries", run: runCollectQueries},
+		},
+	},
+	{name: "consume", summary: "gets the details of the videos published by the crawls", run: runConsume},
+	{
+		name:    "sync",
+		summary: "syncs the local data with the web server",
+		subcommands: []command{
+			{name: "channels", summary: "syncs the channels from the web server", run: runSyncChannels},
+		},
+	},
+	{
+		name:    "keys",
+		summary: "manages the youtube api keys",
+		subcommands: []command{
+			{name: "list", summary: "lists the keys and their quota", run: runKeysList},
+			{name: "add", summary: "adds a key", run: runKeysAdd},
+			{name: "disable", summary: "disables a key", run: runKeysDisable},
+		},
+	},
+	{
+		name:    "blacklist",
+		summary: "manages the blacklisted channels",
+		subcommands: []command{
+			{name: "add", summary: "blacklists a channel", run: runBlacklistAdd},
+			{name: "list", summary: "lists the blacklisted channels", run: runBlacklistList},
+		},
+	},
+	{
+		name:    "video",
+		summary: "inspects the videos",
+		subcommands: []command{
+			{name: "inspect", summary: "shows what is known about a video", run: runVideoInspect},
+		},
+	},
+	{name: "daemon", summary: "runs the jobs on their schedules with the consumer", run: runDaemonCommand},
+}
+
+// dispatch finds the command by the first argument and runs it with the remaining ones
+func dispatch(path string, cmds []command, args []string) error {
+
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		printCommands(path, cmds)
+
+		if len(args) == 0 {
+			return errUsage
+		}
+
+		return nil
+	}
+
+	for _, cmd := range cmds {
+
+		if cmd.name != args[0] {
+			continue
+		}
+
+		if cmd.run != nil {
+
+			// the help of the command doesn't need the settings
+			if !wantsHelp(args[1:]) {
+				if err := loadSettings(); err != nil {
+					return err
+				}
+			}
+
+			return cmd.run(args[1:])
+		}
+
+		return dispatch(path+" "+cmd.name, cmd.subcommands, args[1:])
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	printCommands(path, cmds)
+
+	return errUsage
+}
+
+// loadSettings loads the settings once, before the command runs. The service doesn't start with invalid settings
+func loadSettings() error {
+	return entities.Load(entities.ConfigPath(configFlag))
+}
+
+func wantsHelp(args []string) bool {
+
+	for _, arg := range args {
+		if arg == "-h" || arg == "-help" || arg == "--help" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func printCommands(path string, cmds []command) {
+
+	if path == appName {
+		fmt.Fprintf(os.Stderr, "usage: %s [-config path] <command> [flags] [args]\n\ncommands:\n", path)
+	} else {
+		fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [args]\n\ncommands:\n", path)
+	}
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+
+	w.Flush()
+
+	fmt.Fprintf(os.Stderr, "\nrun '%s <command> -h' for the help of the command\n", path)
+}
+
+// newFlagSet creates the flags of the command with its help text
+func newFlagSet(name string, args string, help string) *flag.FlagSet {
+
+	fs := flag.NewFlagSet(appName+" "+name, flag.ContinueOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s %s [flags] %s\n\n%s\n", appName, name, args, help)
+
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+
+		if hasFlags {
+			fmt.Fprintln(os.Stderr, "\nflags:")
+			fs.PrintDefaults()
+		}
+	}
+
+	return fs
+}
+
+// parseFlags parses the flags and checks the number of the positional arguments
+func parseFlags(fs *flag.FlagSet, args []string, positional int) error {
+
+	err := fs.Parse(args)
+
+	if err == flag.ErrHelp {
+		return flag.ErrHelp
+	}
+
+	if err != nil {
+		return errUsage
+	}
+
+	if fs.NArg() != positional {
+		fs.Usage()
+		return errUsage
+	}
+
+	return nil
+}
+
+// splitList reads a comma separated list, ignoring the empty items
+func splitList(list string) []string {
+
+	var res []string
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+
+	return res
+}
+
+func runCollectPlaylists(args []string) error {
+
+	fs := newFlagSet("collect playlists", "", "Crawls the playlists and publishes their videos to the videos queue.")
+	ids := fs.String("id", "", "comma separated playlist ids, instead of the playlists from the settings")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	playlists := entities.GetPlaylists()
+
+	if *ids != "" {
+		playlists = splitList(*ids)
+	}
+
+	runService(func(ctx context.Context) {
+		collectPlaylists(ctx, playlists)
+	})
+
+	return nil
+}
+
+func runCollectChannels(args []string) error {
+
+	fs := newFlagSet("collect channels", "", "Crawls the videos of the channels and publishes them to the videos queue.")
+	sync := fs.Bool("sync", true, "syncs the channels from the web server before the crawl")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	runService(func(ctx context.Context) {
+		if *sync {
+			channelsWebServer(ctx)
+		}
+
+		if ctx.Err() == nil {
+			channelsSearch(ctx)
+		}
+	})
+
+	return nil
+}
+
+func runCollectCategories(args []string) error {
+
+	fs := newFlagSet("collect categories", "", "Searches the videos of each category and publishes them to the videos queue.")
+	ids := fs.String("category", "", "comma separated category ids, instead of the categories from the settings")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	categories := entities.GetCategories()
+
+	if *ids != "" {
+		categories = splitList(*ids)
+	}
+
+	runService(func(ctx context.Context) {
+		searchCategories(ctx, categories)
+	})
+
+	return nil
+}
+
+func runCollectLocations(args []string) error {
+
+	fs := newFlagSet("collect locations", "", "Searches the upcoming videos around each location and publishes them to the videos queue.")
+	names := fs.String("name", "", "comma separated location names, instead of all the locations from the settings")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	locations := entities.GetLocations()
+
+	if *names != "" {
+
+		wanted := map[string]bool{}
+		for _, name := range splitList(*names) {
+			wanted[name] = true
+		}
+
+		var selected []entities.Location
+		for _, location := range locations {
+			if wanted[location.Name] {
+				selected = append(selected, location)
+				delete(wanted, location.Name)
+			}
+		}
+
+		for name := range wanted {
+			return errors.New("unknown location " + name)
+		}
+
+		locations = selected
+	}
+
+	runService(func(ctx context.Context) {
+		sweepLocations(ctx, locations)
+	})
+
+	return nil
+}
+
+func runCollectQueries(args []string) error {
+
+	fs := newFlagSet("collect queries", "", "Searches the videos by each keyword query and publishes them to the videos queue.")
+	names := fs.String("name", "", "comma separated query names, instead of all the queries from the settings")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	queries := entities.GetQueries()
+
+	if *names != "" {
+
+		wanted := map[string]bool{}
+		for _, name := range splitList(*names) {
+			wanted[name] = true
+		}
+
+		var selected []entities.Query
+		for _, query := range queries {
+			if wanted[query.Name] {
+				selected = append(selected, query)
+				delete(wanted, query.Name)
+			}
+		}
+
+		for name := range wanted {
+			return errors.New("unknown query " + name)
+		}
+
+		queries = selected
+	}
+
+	runService(func(ctx context.Context) {
+		runQueries(ctx, queries)
+	})
+
+	return nil
+}
+
+func runConsume(args []string) error {
+
+	fs := newFlagSet("consume", "", "Consumes the videos queue: gets the details of the videos and publishes them to the processor.\nRuns until it is stopped.")
+	workers := fs.Int("workers", 0, "messages handled at the same time, instead of the value from the settings")
+	prefetch := fs.Int("prefetch", 0, "messages received by each worker in advance, instead of the value from the settings")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	runService(func(ctx context.Context) {
+		logrus.Info("[  *  ] Processing all the videos from the queue ...")
+		consumeVideo(ctx, *workers, *prefetch)
+	})
+
+	return nil
+}
+
+func runDaemonCommand(args []string) error {
+
+	fs := newFlagSet("daemon", "", "Runs the jobs on the schedules from the settings, with the consumer.\nRuns until it is stopped.")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	runService(func(ctx context.Context) {
+		logrus.Info("[  *  ] Running as a daemon ...")
+		runDaemon(ctx)
+	})
+
+	return nil
+}
+
+func runSyncChannels(args []string) error {
+
+	fs := newFlagSet("sync channels", "", "Replaces the local channels by the ones from the web server.")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	initStorage()
+
+	return NewChannelWebListService().UpdateChannelsFromWebServer(context.Background())
+}
+
+func runKeysList(args []string) error {
+
+	fs := newFlagSet("keys list", "", "Lists the keys, their status and the quota used today.")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	initStorage()
+
+	now := time.Now()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tKEY\tSTATUS\tQUOTA USED\tREMAINING")
+
+	for _, auth := range repositories.Keys.Index() {
+
+		status := "active"
+
+		if !auth.ExpiredAt.IsZero() {
+			status = "expired (" + auth.ExpiredReason + ")"
+		}
+
+		used := auth.QuotaUsed
+
+		// the quota saved is from a previous day
+		if !dao.IsQuotaDay(auth.QuotaDate, now) {
+			used = 0
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\n", auth.ID, dao.Fingerprint(auth.Token), status, used, keyPool.Remaining(auth.Token))
+	}
+
+	return w.Flush()
+}
+
+func runKeysAdd(args []string) error {
+
+	fs := newFlagSet("keys add", "<key>", "Adds a youtube api key to the pool.")
+
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	initStorage()
+
+	token := fs.Arg(0)
+
+	if repositories.Keys.Show(token).Token != "" {
+		return errors.New("the key already exists")
+	}
+
+	err := repositories.Keys.Insert(dao.Authorization{Token: token})
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("key " + dao.Fingerprint(token) + " added")
+
+	return nil
+}
+
+func runKeysDisable(args []string) error {
+
+	fs := newFlagSet("keys disable", "<key>", "Removes the key from the pool. Keys disabled by quota come back after the daily reset, the others don't.")
+	reason := fs.String("reason", dao.ExpiredReasonInvalid, "why the key is disabled: "+dao.ExpiredReasonQuota+", "+dao.ExpiredReasonInvalid+" or "+dao.ExpiredReasonAccessNotConfigured)
+
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	switch *reason {
+	case dao.ExpiredReasonQuota, dao.ExpiredReasonInvalid, dao.ExpiredReasonAccessNotConfigured:
+	default:
+		fs.Usage()
+		return errUsage
+	}
+
+	initStorage()
+
+	token := fs.Arg(0)
+
+	if repositories.Keys.Show(token).Token == "" {
+		return errors.New("key not found")
+	}
+
+	err := repositories.Keys.UpdateExpiredAt(token, *reason)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("key " + dao.Fingerprint(token) + " disabled")
+
+	return nil
+}
+
+func runBlacklistAdd(args []string) error {
+
+	fs := newFlagSet("blacklist add", "<channel id>", "Blacklists the channel: its videos are not crawled nor sent to the processor.")
+
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	initStorage()
+
+	channelID := fs.Arg(0)
+
+	if isBlacklisted(channelID) {
+		return errors.New("the channel is already blacklisted")
+	}
+
+	err := repositories.Blacklist.Insert(dao.Blacklist{ChannelID: channelID})
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("channel " + channelID + " blacklisted")
+
+	return nil
+}
+
+func runBlacklistList(args []string) error {
+
+	fs := newFlagSet("blacklist list", "", "Lists the blacklisted channels.")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	initStorage()
+
+	list, err := repositories.Blacklist.Index()
+
+	if err != nil {
+		return err
+	}
+
+	for _, bck := range list {
+		fmt.Println(bck.ChannelID)
+	}
+
+	return nil
+}
+
+func runVideoInspect(args []string) error {
+
+	fs := newFlagSet("video inspect", "<video id>", "Shows if the video has already been collected and its details from the youtube api.")
+	local := fs.Bool("local", false, "only shows the local data, without calling the youtube api")
+
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	initStorage()
+
+	videoID := fs.Arg(0)
+	video := repositories.Videos.Show(videoID)
+
+	if video.VideoID == "" {
+		fmt.Println("collected:   no")
+	} else {
+		fmt.Println("collected:   " + video.InsertedAt.Format(time.RFC3339))
+		fmt.Println("channel:     " + video.ChannelID)
+		fmt.Printf("blacklisted: %v\n", isBlacklisted(video.ChannelID))
+	}
+
+	if *local {
+		return nil
+	}
+
+	ctx := context.Background()
+
+	var item *youtube.Video
+
+	err := callWithKey(ctx, dao.QuotaCostVideos, func(key string) error {
+
+		youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(key))
+
+		if err != nil {
+			return err
+		}
+
+		response, err := youtubeService.Videos.List(entities.GetParametersVideo().Part).Id(videoID).Context(ctx).Do()
+
+		if err != nil {
+			return err
+		}
+
+		keyPool.Charge(key, dao.QuotaCostVideos)
+
+		if len(response.Items) > 0 {
+			item = response.Items[0]
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if item == nil {
+		return errors.New("the video was not found on youtube")
+	}
+
+	out, err := json.MarshalIndent(item, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(out))
+
+	return nil
+}
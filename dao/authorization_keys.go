@@ -1,7 +1,7 @@
 package dao
 
 import (
-	"errors"
+	"database/sql"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -9,32 +9,45 @@ import (
 )
 
 // ChannelsService struct
-type AuthorizationService struct{}
+type AuthorizationService struct {
+	db *sql.DB
+}
 
 // Channel Model
 type Authorization struct {
-	ID        int
-	Token     string
-	ExpiredAt time.Time
-	CreatedAt time.Time
+	ID            int
+	Token         string
+	ExpiredAt     time.Time
+	ExpiredReason string
+	CreatedAt     time.Time
+	QuotaUsed     int64
+	QuotaDate     time.Time
 }
 
+// Reasons for a key to be expired. Only the keys expired by quota are reinstated automatically
+const (
+	ExpiredReasonQuota               = "quota"
+	ExpiredReasonInvalid             = "invalid"
+	ExpiredReasonAccessNotConfigured = "access_not_configured"
+)
+
 // NewChannelService method
-func NewAuthorizationService() AuthorizationService {
-	return AuthorizationService{}
+func NewAuthorizationService(db *sql.DB) AuthorizationService {
+	return AuthorizationService{db: db}
 }
 
 var (
 	page_name_auth = "authorization_keys"
 	table_auth     = "authorization_keys"
+	columns_auth   = "id, token, expired_at, expired_reason, created_at, quota_used, quota_date"
 )
 
 // Truncate method
 func (s AuthorizationService) Truncate() error {
 
-	db := dbConn()
+	db := s.db
 
-	_, err := db.Exec("TRUNCATE TABLE " + table)
+	_, err := db.Exec("TRUNCATE TABLE " + table_auth)
 
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -51,17 +64,21 @@ func (s AuthorizationService) Truncate() error {
 // Index method
 func (s AuthorizationService) Index() []Authorization {
 
-	db := dbConn()
+	db := s.db
 
-	selSQL, err := db.Query("SELECT * FROM " + table_auth)
+	selSQL, err := db.Query("SELECT " + columns_auth + " FROM " + table_auth)
 
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"err": err.Error(),
 			"dao": page_name_auth,
 		}).Error("Error to retrieve data")
+
+		return []Authorization{}
 	}
 
+	defer selSQL.Close()
+
 	auth := Authorization{}
 	res := []Authorization{}
 
@@ -69,10 +86,13 @@ func (s AuthorizationService) Index() []Authorization {
 
 		var id int
 		var token string
-		var expiredAt time.Time
+		var expiredAt sql.NullTime
+		var expiredReason sql.NullString
 		var createdAt time.Time
+		var quotaUsed int64
+		var quotaDate sql.NullTime
 
-		err = selSQL.Scan(&id, &token, &expiredAt, &createdAt)
+		err = selSQL.Scan(&id, &token, &expiredAt, &expiredReason, &createdAt, &quotaUsed, &quotaDate)
 
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
@@ -84,24 +104,38 @@ func (s AuthorizationService) Index() []Authorization {
 
 		auth.ID = id
 		auth.Token = token
-		auth.ExpiredAt = expiredAt
+		auth.ExpiredAt = expiredAt.Time
+		auth.ExpiredReason = expiredReason.String
 		auth.CreatedAt = createdAt
+		auth.QuotaUsed = quotaUsed
+		auth.QuotaDate = quotaDate.Time
 
 		res = append(res, auth)
 	}
 
-	defer db.Close()
-
 	return res
 
 }
 
+// IndexActive returns only the keys that are not expired
+func (s AuthorizationService) IndexActive() []Authorization {
+
+	res := []Authorization{}
+
+	for _, auth := range s.Index() {
+		if auth.ExpiredAt.IsZero() {
+			res = append(res, auth)
+		}
+	}
+
+	return res
+}
+
 func (s AuthorizationService) Show(token string) Authorization {
 
-	// start connection
-	db := dbConn()
+	db := s.db
 
-	selSQL, err := db.Query("SELECT * FROM "+table_auth+" WHERE token=?", token)
+	selSQL, err := db.Query("SELECT "+columns_auth+" FROM "+table_auth+" WHERE token=?", token)
 
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -109,18 +143,25 @@ func (s AuthorizationService) Show(token string) Authorization {
 			"token": token,
 			"dao":   page_name_auth,
 		}).Error("Error to retrieve by token")
+
+		return Authorization{}
 	}
 
+	defer selSQL.Close()
+
 	auth := Authorization{}
 
 	for selSQL.Next() {
 
 		var id int
 		var token string
-		var expiredAt time.Time
+		var expiredAt sql.NullTime
+		var expiredReason sql.NullString
 		var createdAt time.Time
+		var quotaUsed int64
+		var quotaDate sql.NullTime
 
-		err = selSQL.Scan(&id, &token, &expiredAt, &createdAt)
+		err = selSQL.Scan(&id, &token, &expiredAt, &expiredReason, &createdAt, &quotaUsed, &quotaDate)
 
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
@@ -132,12 +173,13 @@ func (s AuthorizationService) Show(token string) Authorization {
 
 		auth.ID = id
 		auth.Token = token
-		auth.ExpiredAt = expiredAt
+		auth.ExpiredAt = expiredAt.Time
+		auth.ExpiredReason = expiredReason.String
 		auth.CreatedAt = createdAt
+		auth.QuotaUsed = quotaUsed
+		auth.QuotaDate = quotaDate.Time
 	}
 
-	defer db.Close()
-
 	return auth
 
 }
@@ -145,7 +187,7 @@ func (s AuthorizationService) Show(token string) Authorization {
 // Insert key
 func (s AuthorizationService) Insert(auth Authorization) error {
 
-	db := dbConn()
+	db := s.db
 
 	insForm, err := db.Prepare("INSERT INTO " + table_auth + "(token, expired_at) VALUES(?,?)")
 
@@ -158,19 +200,24 @@ func (s AuthorizationService) Insert(auth Authorization) error {
 		return err
 	}
 
-	insForm.Exec(auth.Token, auth.ExpiredAt)
+	defer insForm.Close()
+
+	var expiredAt interface{}
+	if !auth.ExpiredAt.IsZero() {
+		expiredAt = auth.ExpiredAt
+	}
 
-	defer db.Close()
+	insForm.Exec(auth.Token, expiredAt)
 
 	return nil
 }
 
-// Update key
-func (s AuthorizationService) UpdateExpiredAt(token string) error {
+// UpdateExpiredAt expires the key for the given reason
+func (s AuthorizationService) UpdateExpiredAt(token string, reason string) error {
 
-	db := dbConn()
+	db := s.db
 
-	insForm, err := db.Prepare("UPDATE " + table_auth + " SET expired_at=NOW() WHERE token=?")
+	insForm, err := db.Prepare("UPDATE " + table_auth + " SET expired_at=UTC_TIMESTAMP(), expired_reason=? WHERE token=?")
 
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -181,29 +228,51 @@ func (s AuthorizationService) UpdateExpiredAt(token string) error {
 		return err
 	}
 
-	insForm.Exec(token)
+	defer insForm.Close()
 
-	defer db.Close()
+	insForm.Exec(reason, token)
 
 	return nil
 }
 
-// GetGetNewKey method
-func GetNewKey(keys []string, expired bool) (string, error, []string) {
+// ReinstateQuotaExpired clears the keys expired by quota before the given time
+func (s AuthorizationService) ReinstateQuotaExpired(before time.Time) (int64, error) {
+
+	db := s.db
+
+	res, err := db.Exec("UPDATE "+table_auth+" SET expired_at=NULL, expired_reason=NULL, quota_used=0 WHERE expired_reason=? AND expired_at < ?", ExpiredReasonQuota, before)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err": err.Error(),
+			"dao": page_name_auth,
+		}).Error("Error to reinstate the keys expired by quota")
 
-	if len(keys) <= 0 {
-		return "", errors.New("Keys has zero or less elements"), keys
+		return 0, err
 	}
 
-	// get a key
-	key := keys[0]
+	return res.RowsAffected()
+}
+
+// UpdateQuota stores the quota units used by the key on the given quota day
+func (s AuthorizationService) UpdateQuota(token string, used int64, day time.Time) error {
 
-	// remove from the array
-	if expired {
-		keys = keys[1:]
+	db := s.db
 
-		NewAuthorizationService().UpdateExpiredAt(key)
+	updForm, err := db.Prepare("UPDATE " + table_auth + " SET quota_used=?, quota_date=? WHERE token=?")
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err": err.Error(),
+			"dao": page_name_auth,
+		}).Error("error to prepare sql statment to update quota")
+
+		return err
 	}
 
-	return key, nil, keys
+	defer updForm.Close()
+
+	_, err = updForm.Exec(used, day.Format("2006-01-02"), token)
+
+	return err
 }
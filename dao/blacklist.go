@@ -1,6 +1,8 @@
 package dao
 
 import (
+	"database/sql"
+
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 )
@@ -10,20 +12,10 @@ type BlackListResponse struct {
 	ChannelID string
 }
 
-// func dbConn() (db *sql.DB) {
-// 	dbDriver := "mysql"
-// 	dbUser := "root"
-// 	dbPass := ""
-// 	dbName := "soliveboa"
-// 	db, err := sql.Open(dbDriver, dbUser+":"+dbPass+"@/"+dbName)
-// 	if err != nil {
-// 		panic(err.Error())
-// 	}
-// 	return db
-// }
-
 // BlacklistService service
-type BlacklistService struct{}
+type BlacklistService struct {
+	db *sql.DB
+}
 
 // Blacklist model
 type Blacklist struct {
@@ -32,15 +24,14 @@ type Blacklist struct {
 }
 
 // NewBlacklistService new service
-func NewBlacklistService() BlacklistService {
-	return BlacklistService{}
+func NewBlacklistService(db *sql.DB) BlacklistService {
+	return BlacklistService{db: db}
 }
 
 // Index from database
-func (s BlacklistService) Index() []Blacklist {
+func (s BlacklistService) Index() ([]Blacklist, error) {
 
-	// start connection
-	db := dbConn()
+	db := s.db
 
 	selSQL, err := db.Query("SELECT * FROM blacklist")
 
@@ -49,8 +40,12 @@ func (s BlacklistService) Index() []Blacklist {
 			"err": err.Error(),
 			"dao": "blacklist",
 		}).Error("Error to retrieve blacklist")
+
+		return nil, err
 	}
 
+	defer selSQL.Close()
+
 	bck := Blacklist{}
 	res := []Blacklist{}
 
@@ -63,10 +58,12 @@ func (s BlacklistService) Index() []Blacklist {
 
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
-				"err":       err.Error(),
-				"channelID": channelID,
-				"dao":       "blacklist",
+				"err":        err.Error(),
+				"channel_id": channelID,
+				"dao":        "blacklist",
 			}).Error("Error running for")
+
+			return nil, err
 		}
 
 		bck.ID = id
@@ -74,17 +71,14 @@ func (s BlacklistService) Index() []Blacklist {
 		res = append(res, bck)
 	}
 
-	defer db.Close()
-
-	return res
+	return res, selSQL.Err()
 
 }
 
 // Show by id
 func (s BlacklistService) Show(chanID string) Blacklist {
 
-	// start connection
-	db := dbConn()
+	db := s.db
 
 	selSQL, err := db.Query("SELECT * FROM blacklist WHERE channel_id=?", chanID)
 
@@ -94,8 +88,12 @@ func (s BlacklistService) Show(chanID string) Blacklist {
 			"channel_id": chanID,
 			"dao":        "blacklist",
 		}).Error("Error to retrieve blacklist by channel ID")
+
+		return Blacklist{}
 	}
 
+	defer selSQL.Close()
+
 	bck := Blacklist{}
 
 	for selSQL.Next() {
@@ -107,9 +105,9 @@ func (s BlacklistService) Show(chanID string) Blacklist {
 
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
-				"err":       err.Error(),
-				"channelID": channelID,
-				"dao":       "blacklist",
+				"err":        err.Error(),
+				"channel_id": channelID,
+				"dao":        "blacklist",
 			}).Error("Error running for - channel ID")
 		}
 
@@ -117,8 +115,6 @@ func (s BlacklistService) Show(chanID string) Blacklist {
 		bck.ChannelID = channelID
 	}
 
-	defer db.Close()
-
 	return bck
 
 }
@@ -126,7 +122,7 @@ func (s BlacklistService) Show(chanID string) Blacklist {
 // Insert blacklist
 func (s BlacklistService) Insert(bck Blacklist) error {
 
-	db := dbConn()
+	db := s.db
 
 	insForm, err := db.Prepare("INSERT INTO blacklist(channel_id) VALUES(?)")
 
@@ -139,9 +135,35 @@ func (s BlacklistService) Insert(bck Blacklist) error {
 		return err
 	}
 
-	insForm.Exec(bck.ChannelID)
+	defer insForm.Close()
 
-	defer db.Close()
+	insForm.Exec(bck.ChannelID)
 
 	return nil
 }
+
+// BlacklistSet - channels blacklisted, indexed by the channel ID
+type BlacklistSet map[string]bool
+
+// Contains method
+func (b BlacklistSet) Contains(channelID string) bool {
+	return channelID != "" && b[channelID]
+}
+
+// LoadBlacklist reads all the blacklist at once
+func LoadBlacklist(repo BlacklistRepository) (BlacklistSet, error) {
+
+	list, err := repo.Index()
+
+	if err != nil {
+		return nil, err
+	}
+
+	set := BlacklistSet{}
+
+	for _, bck := range list {
+		set[bck.ChannelID] = true
+	}
+
+	return set, nil
+}
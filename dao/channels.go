@@ -1,6 +1,8 @@
 package dao
 
 import (
+	"database/sql"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -8,30 +10,34 @@ import (
 )
 
 // ChannelsService struct
-type ChannelService struct{}
+type ChannelService struct {
+	db *sql.DB
+}
 
 // Channel Model
 type Channel struct {
-	ID         int
-	ChannelID  string
-	FullSynced bool
-	CreatedAt  time.Time
+	ID                int
+	ChannelID         string
+	FullSynced        bool
+	CreatedAt         time.Time
+	UploadsPlaylistID string
 }
 
 // NewChannelService method
-func NewChannelService() ChannelService {
-	return ChannelService{}
+func NewChannelService(db *sql.DB) ChannelService {
+	return ChannelService{db: db}
 }
 
 var (
 	page_name = "channels"
 	table     = "channels"
+	columns   = "id, channel_id, full_synced, created_at, uploads_playlist_id"
 )
 
 // Truncate method
 func (s ChannelService) Truncate() error {
 
-	db := dbConn()
+	db := s.db
 
 	_, err := db.Exec("TRUNCATE TABLE " + table)
 
@@ -50,17 +56,21 @@ func (s ChannelService) Truncate() error {
 // Index method
 func (s ChannelService) Index() []Channel {
 
-	db := dbConn()
+	db := s.db
 
-	selSQL, err := db.Query("SELECT * FROM " + table)
+	selSQL, err := db.Query("SELECT " + columns + " FROM " + table)
 
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"err": err.Error(),
 			"dao": page_name,
 		}).Error("Error to retrieve data")
+
+		return []Channel{}
 	}
 
+	defer selSQL.Close()
+
 	channel := Channel{}
 	res := []Channel{}
 
@@ -70,8 +80,9 @@ func (s ChannelService) Index() []Channel {
 		var channelID string
 		var fullSynced bool
 		var createdAt time.Time
+		var uploadsPlaylistID sql.NullString
 
-		err = selSQL.Scan(&id, &channelID, &fullSynced, &createdAt)
+		err = selSQL.Scan(&id, &channelID, &fullSynced, &createdAt, &uploadsPlaylistID)
 
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
@@ -85,22 +96,69 @@ func (s ChannelService) Index() []Channel {
 		channel.ChannelID = channelID
 		channel.FullSynced = fullSynced
 		channel.CreatedAt = createdAt
+		channel.UploadsPlaylistID = uploadsPlaylistID.String
 
 		res = append(res, channel)
 	}
 
-	defer db.Close()
-
 	return res
 
 }
 
+// Sync keeps only the given channels. Existing rows keep their cached uploads playlist,
+// and a channel already synced locally is not sent back to the backfill.
+// An empty list never removes the channels
+func (s ChannelService) Sync(channels []Channel) (int, error) {
+
+	db := s.db
+
+	c := 0
+	ids := []interface{}{}
+
+	for _, channel := range channels {
+
+		_, err := db.Exec("INSERT INTO "+table+"(channel_id, full_synced) VALUES(?,?) "+
+			"ON DUPLICATE KEY UPDATE full_synced = full_synced OR VALUES(full_synced)", channel.ChannelID, channel.FullSynced)
+
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"err":        err.Error(),
+				"channel_id": channel.ChannelID,
+				"dao":        page_name,
+			}).Error("Error to insert channel")
+
+			continue
+		}
+
+		ids = append(ids, channel.ChannelID)
+		c++
+	}
+
+	// none of the channels has been saved, keep the ones already there
+	if len(ids) <= 0 {
+		return c, nil
+	}
+
+	// remove the channels that are not in the list anymore
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	_, err := db.Exec("DELETE FROM "+table+" WHERE channel_id NOT IN ("+placeholders+")", ids...)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err": err.Error(),
+			"dao": page_name,
+		}).Error("Error to remove the old channels")
+	}
+
+	return c, err
+}
+
 // Insert channel
 func (s ChannelService) Insert(channel Channel) error {
 
-	db := dbConn()
+	db := s.db
 
-	insForm, err := db.Prepare("INSERT INTO videos(channel_id, full_synced) VALUES(?,?)")
+	insForm, err := db.Prepare("INSERT INTO " + table + "(channel_id, full_synced) VALUES(?,?)")
 
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -111,9 +169,45 @@ func (s ChannelService) Insert(channel Channel) error {
 		return err
 	}
 
-	insForm.Exec(channel.ChannelID, channel.FullSynced)
+	defer insForm.Close()
 
-	defer db.Close()
+	insForm.Exec(channel.ChannelID, channel.FullSynced)
 
 	return nil
 }
+
+// UpdateFullSynced method
+func (s ChannelService) UpdateFullSynced(channelID string, fullSynced bool) error {
+
+	db := s.db
+
+	_, err := db.Exec("UPDATE "+table+" SET full_synced=? WHERE channel_id=?", fullSynced, channelID)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":        err.Error(),
+			"channel_id": channelID,
+			"dao":        page_name,
+		}).Error("Error to update full synced")
+	}
+
+	return err
+}
+
+// UpdateUploadsPlaylist caches the uploads playlist of the channel
+func (s ChannelService) UpdateUploadsPlaylist(channelID string, playlistID string) error {
+
+	db := s.db
+
+	_, err := db.Exec("UPDATE "+table+" SET uploads_playlist_id=? WHERE channel_id=?", playlistID, channelID)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":        err.Error(),
+			"channel_id": channelID,
+			"dao":        page_name,
+		}).Error("Error to update the uploads playlist")
+	}
+
+	return err
+}
@@ -1,15 +1,40 @@
 package dao
 
-import "database/sql"
-
-func dbConn() (db *sql.DB) {
-	dbDriver := "mysql"
-	dbUser := "root"
-	dbPass := ""
-	dbName := "soliveboa"
-	db, err := sql.Open(dbDriver, dbUser+":"+dbPass+"@/"+dbName+"?parseTime=true")
+import (
+	"database/sql"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Open creates the connection pool shared by all the DAO services of the process
+func Open(dsn string, maxOpenConns int, maxIdleConns int, connMaxLifetime time.Duration) (*sql.DB, error) {
+
+	cfg, err := mysql.ParseDSN(dsn)
+
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	return db
+
+	// the models scan the datetime columns into time.Time
+	cfg.ParseTime = true
+
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(connMaxLifetime)
+
+	err = db.Ping()
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
 }
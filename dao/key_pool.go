@@ -0,0 +1,332 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Quota cost, in units, of each YouTube Data API call used by the service
+const (
+	QuotaCostSearch        int64 = 100
+	QuotaCostVideos        int64 = 1
+	QuotaCostPlaylistItems int64 = 1
+	QuotaCostChannels      int64 = 1
+
+	// DefaultDailyQuota is the quota given by google to each project per day
+	DefaultDailyQuota int64 = 10000
+)
+
+// ErrNoKeyAvailable is returned when no key has enough quota for the call
+var ErrNoKeyAvailable = errors.New("There are no authorization keys with quota available")
+
+// quotaLocation is the timezone used by google to reset the daily quota
+var quotaLocation = loadQuotaLocation()
+
+func loadQuotaLocation() *time.Location {
+
+	loc, err := time.LoadLocation("America/Los_Angeles")
+
+	if err != nil {
+		return time.FixedZone("PST", -8*60*60)
+	}
+
+	return loc
+}
+
+// QuotaDay returns the quota day (midnight, pacific time) of the given time
+func QuotaDay(t time.Time) time.Time {
+
+	t = t.In(quotaLocation)
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, quotaLocation)
+}
+
+// IsQuotaDay tells if the day stored in the database is the quota day of the given time.
+// The DATE column is read back as midnight of the driver location (UTC by default), not pacific
+// time, so only the calendar day is compared
+func IsQuotaDay(stored time.Time, t time.Time) bool {
+
+	if stored.IsZero() {
+		return false
+	}
+
+	return stored.Format("2006-01-02") == QuotaDay(t).Format("2006-01-02")
+}
+
+// NextQuotaReset returns when the daily quota will be reset after the given time
+func NextQuotaReset(t time.Time) time.Time {
+
+	return QuotaDay(t).AddDate(0, 0, 1)
+}
+
+type pooledKey struct {
+	token string
+	used  int64
+	day   time.Time
+}
+
+// KeyPool keeps the quota used by each authorization key
+type KeyPool struct {
+	mu      sync.Mutex
+	service KeyRepository
+	limit   int64
+	keys    []*pooledKey
+}
+
+// NewKeyPool method
+func NewKeyPool(service KeyRepository, limit int64) *KeyPool {
+
+	if limit <= 0 {
+		limit = DefaultDailyQuota
+	}
+
+	return &KeyPool{service: service, limit: limit}
+}
+
+// Load the keys and the quota already used from the database.
+// Keys expired by quota on a previous quota day are reinstated before loading
+func (p *KeyPool) Load() error {
+
+	now := time.Now()
+	today := QuotaDay(now)
+
+	p.service.ReinstateQuotaExpired(today)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys = nil
+
+	for _, auth := range p.service.IndexActive() {
+
+		k := &pooledKey{token: auth.Token, day: today}
+
+		// the usage stored only counts if it belongs to the current quota day
+		if IsQuotaDay(auth.QuotaDate, now) {
+			k.used = auth.QuotaUsed
+		}
+
+		p.keys = append(p.keys, k)
+	}
+
+	if len(p.keys) <= 0 {
+		return errors.New("Keys has zero or less elements")
+	}
+
+	return nil
+}
+
+// Acquire returns the key with the most remaining quota able to pay the cost
+func (p *KeyPool) Acquire(cost int64) (string, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *pooledKey
+	var bestRemaining int64
+
+	for _, k := range p.keys {
+
+		remaining := p.remaining(k)
+
+		if remaining < cost {
+			continue
+		}
+
+		if best == nil || remaining > bestRemaining {
+			best = k
+			bestRemaining = remaining
+		}
+	}
+
+	if best == nil {
+		return "", ErrNoKeyAvailable
+	}
+
+	return best.token, nil
+}
+
+// Charge registers the cost of a call made with the key
+func (p *KeyPool) Charge(token string, cost int64) {
+
+	p.mu.Lock()
+
+	k := p.find(token)
+
+	if k == nil {
+		p.mu.Unlock()
+		return
+	}
+
+	p.remaining(k)
+	k.used += cost
+
+	used, day := k.used, k.day
+
+	p.mu.Unlock()
+
+	p.persist(token, used, day)
+}
+
+// Exhaust marks the key as without quota until the next reset
+func (p *KeyPool) Exhaust(token string) {
+
+	p.mu.Lock()
+
+	k := p.find(token)
+
+	if k == nil {
+		p.mu.Unlock()
+		return
+	}
+
+	p.remaining(k)
+	k.used = p.limit
+
+	used, day := k.used, k.day
+
+	p.mu.Unlock()
+
+	p.persist(token, used, day)
+	p.service.UpdateExpiredAt(token, ExpiredReasonQuota)
+
+	logrus.WithFields(logrus.Fields{
+		"key_fingerprint": Fingerprint(token),
+		"reset":           NextQuotaReset(time.Now()).Format(time.RFC3339),
+	}).Warning("Key has no more quota available")
+}
+
+// Disable removes the key from the pool until someone fixes it
+func (p *KeyPool) Disable(token string, reason string) {
+
+	p.mu.Lock()
+
+	for i, k := range p.keys {
+		if k.token == token {
+			p.keys = append(p.keys[:i], p.keys[i+1:]...)
+			break
+		}
+	}
+
+	p.mu.Unlock()
+
+	p.service.UpdateExpiredAt(token, reason)
+
+	logrus.WithFields(logrus.Fields{
+		"key_fingerprint": Fingerprint(token),
+		"reason":          reason,
+	}).Error("Key has been disabled")
+}
+
+// RunReinstatement reloads the keys expired by quota right after each daily reset.
+// It blocks until the context is done
+func (p *KeyPool) RunReinstatement(ctx context.Context) {
+
+	for {
+		// gives google a little margin to reset the quota
+		wait := time.Until(NextQuotaReset(time.Now())) + time.Minute
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		err := p.Load()
+
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"err": err.Error(),
+				"dao": page_name_auth,
+			}).Error("Error to reinstate the keys after the quota reset")
+
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"available": p.Available(),
+		}).Info("Keys reinstated after the quota reset")
+	}
+}
+
+// Remaining returns the quota units still available for the key
+func (p *KeyPool) Remaining(token string) int64 {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	k := p.find(token)
+
+	if k == nil {
+		return 0
+	}
+
+	return p.remaining(k)
+}
+
+// Available returns how many keys still have quota
+func (p *KeyPool) Available() int {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := 0
+	for _, k := range p.keys {
+		if p.remaining(k) > 0 {
+			c++
+		}
+	}
+
+	return c
+}
+
+// remaining resets the usage when the quota day has changed. Must be called with the lock held
+func (p *KeyPool) remaining(k *pooledKey) int64 {
+
+	today := QuotaDay(time.Now())
+
+	if !k.day.Equal(today) {
+		k.day = today
+		k.used = 0
+	}
+
+	return p.limit - k.used
+}
+
+func (p *KeyPool) find(token string) *pooledKey {
+
+	for _, k := range p.keys {
+		if k.token == token {
+			return k
+		}
+	}
+
+	return nil
+}
+
+func (p *KeyPool) persist(token string, used int64, day time.Time) {
+
+	err := p.service.UpdateQuota(token, used, day)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":             err.Error(),
+			"key_fingerprint": Fingerprint(token),
+			"dao":             page_name_auth,
+		}).Error("Error to save the quota used by the key")
+	}
+}
+
+// Fingerprint returns a short, safe to log, identification of the key
+func Fingerprint(token string) string {
+
+	if len(token) <= 6 {
+		return "***"
+	}
+
+	return "***" + token[len(token)-6:]
+}
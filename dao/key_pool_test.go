@@ -0,0 +1,224 @@
+package dao_test
+
+import (
+	"testing"
+	"time"
+
+	"soliveboa/youtuber/v2/dao"
+	"soliveboa/youtuber/v2/dao/memory"
+)
+
+func newPool(t *testing.T, limit int64, tokens ...string) (*dao.KeyPool, dao.Repositories) {
+
+	t.Helper()
+
+	repos := memory.NewRepositories()
+
+	for _, token := range tokens {
+		repos.Keys.Insert(dao.Authorization{Token: token})
+	}
+
+	pool := dao.NewKeyPool(repos.Keys, limit)
+
+	if err := pool.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	return pool, repos
+}
+
+func TestKeyPoolLoadKeepsTodayUsage(t *testing.T) {
+
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		day           time.Time
+		used          int64
+		wantRemaining int64
+		wantAvailable int
+	}{
+		{"usage from today", dao.QuotaDay(now), 60, 40, 1},
+		{"whole quota used today", dao.QuotaDay(now), 100, 0, 0},
+		{"usage from yesterday", dao.QuotaDay(now).AddDate(0, 0, -1), 60, 100, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			_, repos := newPool(t, 100, "token-aaaaaa")
+
+			if err := repos.Keys.UpdateQuota("token-aaaaaa", tt.used, tt.day); err != nil {
+				t.Fatalf("UpdateQuota: %v", err)
+			}
+
+			// a restart: a new pool loaded from the stored usage
+			pool := dao.NewKeyPool(repos.Keys, 100)
+
+			if err := pool.Load(); err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+
+			if got := pool.Remaining("token-aaaaaa"); got != tt.wantRemaining {
+				t.Errorf("Remaining = %d, want %d", got, tt.wantRemaining)
+			}
+
+			if got := pool.Available(); got != tt.wantAvailable {
+				t.Errorf("Available = %d, want %d", got, tt.wantAvailable)
+			}
+		})
+	}
+}
+
+func TestIsQuotaDay(t *testing.T) {
+
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+
+	if err != nil {
+		t.Skip("no timezone database")
+	}
+
+	// 2026-10-18 22:00 in pacific time is already 2026-10-19 in UTC
+	now := time.Date(2026, 10, 18, 22, 0, 0, 0, pacific)
+
+	tests := []struct {
+		name   string
+		stored time.Time
+		want   bool
+	}{
+		{"DATE read as UTC", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), true},
+		{"DATE read as pacific", time.Date(2026, 10, 18, 0, 0, 0, 0, pacific), true},
+		{"UTC day", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), false},
+		{"previous day", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC), false},
+		{"never used", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dao.IsQuotaDay(tt.stored, now); got != tt.want {
+				t.Errorf("IsQuotaDay(%v) = %v, want %v", tt.stored, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyPoolAcquire(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		charges map[string]int64
+		cost    int64
+		want    string
+		wantErr error
+	}{
+		{"the key with the most quota", map[string]int64{"key-aaaaaa": 300}, 100, "key-bbbbbb", nil},
+		{"the other one after charges", map[string]int64{"key-bbbbbb": 500}, 100, "key-aaaaaa", nil},
+		{"a key able to pay the cost", map[string]int64{"key-aaaaaa": 950, "key-bbbbbb": 920}, 60, "key-bbbbbb", nil},
+		{"no key able to pay the cost", map[string]int64{"key-aaaaaa": 950, "key-bbbbbb": 920}, 100, "", dao.ErrNoKeyAvailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			pool, _ := newPool(t, 1000, "key-aaaaaa", "key-bbbbbb")
+
+			for token, cost := range tt.charges {
+				pool.Charge(token, cost)
+			}
+
+			got, err := pool.Acquire(tt.cost)
+
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("Acquire(%d) = %q, %v, want %q, %v", tt.cost, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyPoolChargeIsStored(t *testing.T) {
+
+	pool, repos := newPool(t, 1000, "key-aaaaaa")
+
+	pool.Charge("key-aaaaaa", dao.QuotaCostSearch)
+	pool.Charge("key-aaaaaa", dao.QuotaCostVideos)
+
+	stored := repos.Keys.Show("key-aaaaaa")
+
+	if stored.QuotaUsed != 101 || !dao.IsQuotaDay(stored.QuotaDate, time.Now()) {
+		t.Errorf("stored usage = %d on %v, want 101 today", stored.QuotaUsed, stored.QuotaDate)
+	}
+
+	if got := pool.Remaining("key-aaaaaa"); got != 899 {
+		t.Errorf("Remaining = %d, want 899", got)
+	}
+}
+
+func TestKeyPoolExhaustAndDisable(t *testing.T) {
+
+	pool, repos := newPool(t, 1000, "key-aaaaaa", "key-bbbbbb", "key-cccccc")
+
+	pool.Exhaust("key-aaaaaa")
+	pool.Disable("key-bbbbbb", dao.ExpiredReasonInvalid)
+
+	if got := pool.Available(); got != 1 {
+		t.Errorf("Available = %d, want 1", got)
+	}
+
+	if got, _ := pool.Acquire(dao.QuotaCostSearch); got != "key-cccccc" {
+		t.Errorf("Acquire = %q, want the only key left", got)
+	}
+
+	tests := []struct {
+		token      string
+		wantReason string
+	}{
+		{"key-aaaaaa", dao.ExpiredReasonQuota},
+		{"key-bbbbbb", dao.ExpiredReasonInvalid},
+		{"key-cccccc", ""},
+	}
+
+	for _, tt := range tests {
+		if got := repos.Keys.Show(tt.token).ExpiredReason; got != tt.wantReason {
+			t.Errorf("%s expired reason = %q, want %q", tt.token, got, tt.wantReason)
+		}
+	}
+}
+
+func TestKeyPoolLoadReinstatesQuotaExpired(t *testing.T) {
+
+	yesterday := dao.QuotaDay(time.Now()).Add(-time.Hour)
+
+	repos := memory.NewRepositories()
+	repos.Keys.Insert(dao.Authorization{Token: "key-aaaaaa", ExpiredAt: yesterday, ExpiredReason: dao.ExpiredReasonQuota, QuotaUsed: 10000})
+	repos.Keys.Insert(dao.Authorization{Token: "key-bbbbbb", ExpiredAt: yesterday, ExpiredReason: dao.ExpiredReasonInvalid})
+	repos.Keys.Insert(dao.Authorization{Token: "key-cccccc", ExpiredAt: time.Now(), ExpiredReason: dao.ExpiredReasonQuota})
+
+	pool := dao.NewKeyPool(repos.Keys, 1000)
+
+	if err := pool.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// only the key expired by quota before the reset is back
+	if got := pool.Available(); got != 1 {
+		t.Errorf("Available = %d, want 1", got)
+	}
+
+	if got := pool.Remaining("key-aaaaaa"); got != 1000 {
+		t.Errorf("Remaining = %d, want the whole quota", got)
+	}
+}
+
+func TestNextQuotaReset(t *testing.T) {
+
+	now := time.Now()
+	reset := dao.NextQuotaReset(now)
+
+	if !reset.After(now) || reset.Sub(now) > 25*time.Hour {
+		t.Errorf("NextQuotaReset(%v) = %v, want the next pacific midnight", now, reset)
+	}
+
+	if !dao.QuotaDay(reset).Equal(reset) {
+		t.Errorf("NextQuotaReset = %v, want a midnight", reset)
+	}
+}
@@ -0,0 +1,438 @@
+// Package memory keeps the collector storage in memory.
+// It is used to run the collector without databases (dry runs and offline tests)
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"soliveboa/youtuber/v2/dao"
+)
+
+// NewRepositories creates empty in memory repositories
+func NewRepositories() dao.Repositories {
+
+	return dao.Repositories{
+		Videos:      &Videos{},
+		Channels:    &Channels{},
+		Blacklist:   &Blacklist{},
+		Keys:        &Keys{},
+		Checkpoints: &Checkpoints{},
+		Skipped:     &Skipped{},
+	}
+}
+
+// Videos repository
+type Videos struct {
+	mu     sync.Mutex
+	videos []dao.Videos
+}
+
+// Index method
+func (r *Videos) Index() []dao.Videos {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]dao.Videos{}, r.videos...)
+}
+
+// Show by video ID
+func (r *Videos) Show(videoID string) dao.Videos {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, v := range r.videos {
+		if v.VideoID == videoID {
+			return v
+		}
+	}
+
+	return dao.Videos{}
+}
+
+// Existing method
+func (r *Videos) Existing(videoIDs []string) (map[string]bool, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wanted := map[string]bool{}
+	for _, id := range videoIDs {
+		wanted[id] = true
+	}
+
+	res := map[string]bool{}
+	for _, v := range r.videos {
+		if wanted[v.VideoID] {
+			res[v.VideoID] = true
+		}
+	}
+
+	return res, nil
+}
+
+// Insert method
+func (r *Videos) Insert(videos dao.Videos) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	videos.ID = len(r.videos) + 1
+	videos.InsertedAt = time.Now()
+	r.videos = append(r.videos, videos)
+
+	return nil
+}
+
+// Channels repository
+type Channels struct {
+	mu       sync.Mutex
+	channels []dao.Channel
+	lastID   int
+}
+
+// Truncate method
+func (r *Channels) Truncate() error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.channels = nil
+
+	return nil
+}
+
+// Index method
+func (r *Channels) Index() []dao.Channel {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]dao.Channel{}, r.channels...)
+}
+
+// Sync method
+func (r *Channels) Sync(channels []dao.Channel) (int, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(channels) <= 0 {
+		return 0, nil
+	}
+
+	res := []dao.Channel{}
+
+	for _, channel := range channels {
+
+		if current := r.find(channel.ChannelID); current != nil {
+			current.FullSynced = current.FullSynced || channel.FullSynced
+			res = append(res, *current)
+			continue
+		}
+
+		res = append(res, r.create(channel))
+	}
+
+	r.channels = res
+
+	return len(res), nil
+}
+
+// Insert method
+func (r *Channels) Insert(channel dao.Channel) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.channels = append(r.channels, r.create(channel))
+
+	return nil
+}
+
+// UpdateFullSynced method
+func (r *Channels) UpdateFullSynced(channelID string, fullSynced bool) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c := r.find(channelID); c != nil {
+		c.FullSynced = fullSynced
+	}
+
+	return nil
+}
+
+// UpdateUploadsPlaylist method
+func (r *Channels) UpdateUploadsPlaylist(channelID string, playlistID string) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c := r.find(channelID); c != nil {
+		c.UploadsPlaylistID = playlistID
+	}
+
+	return nil
+}
+
+func (r *Channels) create(channel dao.Channel) dao.Channel {
+
+	r.lastID++
+	channel.ID = r.lastID
+	channel.CreatedAt = time.Now()
+
+	return channel
+}
+
+func (r *Channels) find(channelID string) *dao.Channel {
+
+	for i := range r.channels {
+		if r.channels[i].ChannelID == channelID {
+			return &r.channels[i]
+		}
+	}
+
+	return nil
+}
+
+// Blacklist repository
+type Blacklist struct {
+	mu   sync.Mutex
+	list []dao.Blacklist
+}
+
+// Index method
+func (r *Blacklist) Index() ([]dao.Blacklist, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]dao.Blacklist{}, r.list...), nil
+}
+
+// Show by channel ID
+func (r *Blacklist) Show(chanID string) dao.Blacklist {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, bck := range r.list {
+		if bck.ChannelID == chanID {
+			return bck
+		}
+	}
+
+	return dao.Blacklist{}
+}
+
+// Insert method
+func (r *Blacklist) Insert(bck dao.Blacklist) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bck.ID = len(r.list) + 1
+	r.list = append(r.list, bck)
+
+	return nil
+}
+
+// Keys repository
+type Keys struct {
+	mu   sync.Mutex
+	keys []dao.Authorization
+}
+
+// Index method
+func (r *Keys) Index() []dao.Authorization {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]dao.Authorization{}, r.keys...)
+}
+
+// IndexActive method
+func (r *Keys) IndexActive() []dao.Authorization {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := []dao.Authorization{}
+	for _, auth := range r.keys {
+		if auth.ExpiredAt.IsZero() {
+			res = append(res, auth)
+		}
+	}
+
+	return res
+}
+
+// Show by token
+func (r *Keys) Show(token string) dao.Authorization {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if auth := r.find(token); auth != nil {
+		return *auth
+	}
+
+	return dao.Authorization{}
+}
+
+// Insert method
+func (r *Keys) Insert(auth dao.Authorization) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	auth.ID = len(r.keys) + 1
+	auth.CreatedAt = time.Now()
+	r.keys = append(r.keys, auth)
+
+	return nil
+}
+
+// UpdateExpiredAt method
+func (r *Keys) UpdateExpiredAt(token string, reason string) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if auth := r.find(token); auth != nil {
+		auth.ExpiredAt = time.Now().UTC()
+		auth.ExpiredReason = reason
+	}
+
+	return nil
+}
+
+// UpdateQuota method
+func (r *Keys) UpdateQuota(token string, used int64, day time.Time) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// stored like the DATE column: the calendar day, read back as midnight UTC
+	if auth := r.find(token); auth != nil {
+		auth.QuotaUsed = used
+		auth.QuotaDate, _ = time.Parse("2006-01-02", day.Format("2006-01-02"))
+	}
+
+	return nil
+}
+
+// ReinstateQuotaExpired method
+func (r *Keys) ReinstateQuotaExpired(before time.Time) (int64, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var c int64
+
+	for i := range r.keys {
+
+		auth := &r.keys[i]
+
+		if auth.ExpiredReason == dao.ExpiredReasonQuota && auth.ExpiredAt.Before(before) {
+			auth.ExpiredAt = time.Time{}
+			auth.ExpiredReason = ""
+			auth.QuotaUsed = 0
+			c++
+		}
+	}
+
+	return c, nil
+}
+
+func (r *Keys) find(token string) *dao.Authorization {
+
+	for i := range r.keys {
+		if r.keys[i].Token == token {
+			return &r.keys[i]
+		}
+	}
+
+	return nil
+}
+
+// Checkpoints repository
+type Checkpoints struct {
+	mu     sync.Mutex
+	tokens map[string]dao.TokenRecovery
+}
+
+// Checkpoint method
+func (r *Checkpoints) Checkpoint(crawlKey string) (dao.TokenRecovery, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.tokens[crawlKey], nil
+}
+
+// Save method
+func (r *Checkpoints) Save(crawlKey string, nextToken string) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tokens == nil {
+		r.tokens = map[string]dao.TokenRecovery{}
+	}
+
+	tkr, ok := r.tokens[crawlKey]
+
+	if !ok {
+		tkr = dao.TokenRecovery{ID: len(r.tokens) + 1, CrawlKey: crawlKey, InsertedAt: time.Now()}
+	}
+
+	tkr.NextToken = nextToken
+	tkr.UpdatedAt = time.Now()
+	r.tokens[crawlKey] = tkr
+
+	return nil
+}
+
+// Clear method
+func (r *Checkpoints) Clear(crawlKey string) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.tokens, crawlKey)
+
+	return nil
+}
+
+// Skipped repository
+type Skipped struct {
+	mu     sync.Mutex
+	videos []dao.SkippedVideo
+}
+
+// Index method
+func (r *Skipped) Index() []dao.SkippedVideo {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]dao.SkippedVideo{}, r.videos...)
+}
+
+// Insert method
+func (r *Skipped) Insert(v dao.SkippedVideo) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v.ID = len(r.videos) + 1
+	v.SkippedAt = time.Now()
+	r.videos = append(r.videos, v)
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// VideoRepository - videos already sent to the processor
+type VideoRepository interface {
+	Index() []Videos
+	Show(videoID string) Videos
+	Existing(videoIDs []string) (map[string]bool, error)
+	Insert(videos Videos) error
+}
+
+// ChannelRepository - channels registered in the web server
+type ChannelRepository interface {
+	Truncate() error
+	Index() []Channel
+	Sync(channels []Channel) (int, error)
+	Insert(channel Channel) error
+	UpdateFullSynced(channelID string, fullSynced bool) error
+	UpdateUploadsPlaylist(channelID string, playlistID string) error
+}
+
+// BlacklistRepository - channels that must not be collected
+type BlacklistRepository interface {
+	Index() ([]Blacklist, error)
+	Show(chanID string) Blacklist
+	Insert(bck Blacklist) error
+}
+
+// KeyRepository - youtube api authorization keys
+type KeyRepository interface {
+	Index() []Authorization
+	IndexActive() []Authorization
+	Show(token string) Authorization
+	Insert(auth Authorization) error
+	UpdateExpiredAt(token string, reason string) error
+	UpdateQuota(token string, used int64, day time.Time) error
+	ReinstateQuotaExpired(before time.Time) (int64, error)
+}
+
+// CheckpointRepository - page tokens of the paged crawls
+type CheckpointRepository interface {
+	Checkpoint(crawlKey string) (TokenRecovery, error)
+	Save(crawlKey string, nextToken string) error
+	Clear(crawlKey string) error
+}
+
+// SkippedVideoRepository - videos dropped before being sent to the processor
+type SkippedVideoRepository interface {
+	Index() []SkippedVideo
+	Insert(v SkippedVideo) error
+}
+
+// Repositories - all the storage used by the collector
+type Repositories struct {
+	Videos      VideoRepository
+	Channels    ChannelRepository
+	Blacklist   BlacklistRepository
+	Keys        KeyRepository
+	Checkpoints CheckpointRepository
+	Skipped     SkippedVideoRepository
+}
+
+// NewMySQLRepositories builds the repositories on top of the shared mysql pool
+func NewMySQLRepositories(db *sql.DB) Repositories {
+
+	return Repositories{
+		Videos:      NewVideosService(db),
+		Channels:    NewChannelService(db),
+		Blacklist:   NewBlacklistService(db),
+		Keys:        NewAuthorizationService(db),
+		Checkpoints: NewTokenRecoveryService(db),
+		Skipped:     NewSkippedVideosService(db),
+	}
+}
@@ -0,0 +1,92 @@
+package dao
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+)
+
+// SkippedVideosService service
+type SkippedVideosService struct {
+	db *sql.DB
+}
+
+// SkippedVideo model - video dropped before being sent to the processor
+type SkippedVideo struct {
+	ID        int
+	VideoID   string
+	ChannelID string
+	Reason    string
+	Source    string
+	SkippedAt time.Time
+}
+
+// Reasons for a video to be skipped
+const (
+	SkippedReasonBlacklist = "blacklist"
+)
+
+// NewSkippedVideosService new service
+func NewSkippedVideosService(db *sql.DB) SkippedVideosService {
+	return SkippedVideosService{db: db}
+}
+
+// Index from database
+func (s SkippedVideosService) Index() []SkippedVideo {
+
+	db := s.db
+
+	selSQL, err := db.Query("SELECT id, video_id, channel_id, reason, source, skipped_at FROM skipped_videos")
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err": err.Error(),
+			"dao": "skipped_videos",
+		}).Error("Error to retrieve data")
+
+		return []SkippedVideo{}
+	}
+
+	defer selSQL.Close()
+
+	res := []SkippedVideo{}
+
+	for selSQL.Next() {
+
+		v := SkippedVideo{}
+
+		err = selSQL.Scan(&v.ID, &v.VideoID, &v.ChannelID, &v.Reason, &v.Source, &v.SkippedAt)
+
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"err": err.Error(),
+				"id":  v.ID,
+				"dao": "skipped_videos",
+			}).Error("Error running for")
+		}
+
+		res = append(res, v)
+	}
+
+	return res
+}
+
+// Insert skipped video
+func (s SkippedVideosService) Insert(v SkippedVideo) error {
+
+	db := s.db
+
+	_, err := db.Exec("INSERT INTO skipped_videos(video_id, channel_id, reason, source) VALUES(?,?,?,?)", v.VideoID, v.ChannelID, v.Reason, v.Source)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":      err.Error(),
+			"video_id": v.VideoID,
+			"dao":      "skipped_videos",
+		}).Error("Error to insert skipped video")
+	}
+
+	return err
+}
@@ -1,6 +1,7 @@
 package dao
 
 import (
+	"database/sql"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -8,62 +9,88 @@ import (
 )
 
 // TokenRecoveryService service
-type TokenRecoveryService struct{}
+type TokenRecoveryService struct {
+	db *sql.DB
+}
 
 // TokenRecovery model
+// CrawlKey identifies the paged crawl (ex: channel:<id>, category:<id>, playlist:<id>)
 type TokenRecovery struct {
 	ID         int
+	CrawlKey   string
 	NextToken  string
 	InsertedAt time.Time
+	UpdatedAt  time.Time
 }
 
 // NewTokenRecoveryService new service
-func NewTokenRecoveryService() TokenRecoveryService {
-	return TokenRecoveryService{}
+func NewTokenRecoveryService(db *sql.DB) TokenRecoveryService {
+	return TokenRecoveryService{db: db}
+}
+
+var columns_token_recovery = "id, crawl_key, next_token, inserted_at, updated_at"
+
+func scanTokenRecovery(selSQL *sql.Rows) (TokenRecovery, error) {
+
+	var id int
+	var crawlKey string
+	var token string
+	var insertAt time.Time
+	var updatedAt sql.NullTime
+
+	err := selSQL.Scan(&id, &crawlKey, &token, &insertAt, &updatedAt)
+
+	tkr := TokenRecovery{
+		ID:         id,
+		CrawlKey:   crawlKey,
+		NextToken:  token,
+		InsertedAt: insertAt,
+		UpdatedAt:  updatedAt.Time,
+	}
+
+	if tkr.UpdatedAt.IsZero() {
+		tkr.UpdatedAt = insertAt
+	}
+
+	return tkr, err
 }
 
 // Index from database
 func (s TokenRecoveryService) Index() []TokenRecovery {
 
-	// start connection
-	db := dbConn()
+	db := s.db
 
-	selSQL, err := db.Query("SELECT * FROM token_recovery")
+	selSQL, err := db.Query("SELECT " + columns_token_recovery + " FROM token_recovery")
 
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"err": err.Error(),
 			"dao": "token_recovery",
 		}).Error("Error to retrieve data")
+
+		return []TokenRecovery{}
 	}
 
-	tkr := TokenRecovery{}
+	defer selSQL.Close()
+
 	res := []TokenRecovery{}
 
 	for selSQL.Next() {
 
-		var id int
-		var token string
-		var insertAt time.Time
-
-		err = selSQL.Scan(&id, &token, &insertAt)
+		tkr, err := scanTokenRecovery(selSQL)
 
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"err":   err.Error(),
-				"id":    id,
-				"token": token,
+				"id":    tkr.ID,
+				"token": tkr.NextToken,
 				"dao":   "token_recovery",
 			}).Error("Error running for")
 		}
 
-		tkr.ID = id
-		tkr.NextToken = token
 		res = append(res, tkr)
 	}
 
-	defer db.Close()
-
 	return res
 
 }
@@ -71,10 +98,9 @@ func (s TokenRecoveryService) Index() []TokenRecovery {
 // Show by id
 func (s TokenRecoveryService) Show(tokenID string) TokenRecovery {
 
-	// start connection
-	db := dbConn()
+	db := s.db
 
-	selSQL, err := db.Query("SELECT * FROM token_recovery WHERE id=?", tokenID)
+	selSQL, err := db.Query("SELECT "+columns_token_recovery+" FROM token_recovery WHERE id=?", tokenID)
 
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -82,44 +108,71 @@ func (s TokenRecoveryService) Show(tokenID string) TokenRecovery {
 			"id":  tokenID,
 			"dao": "token_recovery",
 		}).Error("Error to retrieve by ID")
+
+		return TokenRecovery{}
 	}
 
+	defer selSQL.Close()
+
 	tkr := TokenRecovery{}
 
 	for selSQL.Next() {
 
-		var id int
-		var token string
-		var insertAt time.Time
-
-		err = selSQL.Scan(&id, &token, &insertAt)
+		tkr, err = scanTokenRecovery(selSQL)
 
 		if err != nil {
 			logrus.WithFields(logrus.Fields{
 				"err":   err.Error(),
-				"id":    id,
-				"token": token,
+				"id":    tkr.ID,
+				"token": tkr.NextToken,
 				"dao":   "token_recovery",
 			}).Error("Error running by id")
 		}
+	}
+
+	return tkr
 
-		tkr.ID = id
-		tkr.NextToken = token
-		tkr.InsertedAt = insertAt
+}
+
+// Checkpoint returns the last page token saved by the crawl
+func (s TokenRecoveryService) Checkpoint(crawlKey string) (TokenRecovery, error) {
+
+	db := s.db
+
+	selSQL, err := db.Query("SELECT "+columns_token_recovery+" FROM token_recovery WHERE crawl_key=?", crawlKey)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":       err.Error(),
+			"crawl_key": crawlKey,
+			"dao":       "token_recovery",
+		}).Error("Error to retrieve by crawl key")
+
+		return TokenRecovery{}, err
 	}
 
-	defer db.Close()
+	defer selSQL.Close()
 
-	return tkr
+	tkr := TokenRecovery{}
+
+	for selSQL.Next() {
+
+		tkr, err = scanTokenRecovery(selSQL)
+
+		if err != nil {
+			return TokenRecovery{}, err
+		}
+	}
 
+	return tkr, nil
 }
 
 // Insert blacklist
 func (s TokenRecoveryService) Insert(tkr TokenRecovery) error {
 
-	db := dbConn()
+	db := s.db
 
-	insForm, err := db.Prepare("INSERT INTO token_recovery(next_token) VALUES(?)")
+	insForm, err := db.Prepare("INSERT INTO token_recovery(crawl_key, next_token) VALUES(?,?)")
 
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -130,9 +183,46 @@ func (s TokenRecoveryService) Insert(tkr TokenRecovery) error {
 		return err
 	}
 
-	insForm.Exec(tkr.NextToken)
+	defer insForm.Close()
 
-	defer db.Close()
+	insForm.Exec(tkr.CrawlKey, tkr.NextToken)
 
 	return nil
 }
+
+// Save the next page token of the crawl, replacing the previous one
+func (s TokenRecoveryService) Save(crawlKey string, nextToken string) error {
+
+	db := s.db
+
+	_, err := db.Exec("INSERT INTO token_recovery(crawl_key, next_token) VALUES(?,?) "+
+		"ON DUPLICATE KEY UPDATE next_token=VALUES(next_token), updated_at=UTC_TIMESTAMP()", crawlKey, nextToken)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":       err.Error(),
+			"crawl_key": crawlKey,
+			"dao":       "token_recovery",
+		}).Error("Error to save the page token")
+	}
+
+	return err
+}
+
+// Clear removes the checkpoint of a crawl that reached its last page
+func (s TokenRecoveryService) Clear(crawlKey string) error {
+
+	db := s.db
+
+	_, err := db.Exec("DELETE FROM token_recovery WHERE crawl_key=?", crawlKey)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":       err.Error(),
+			"crawl_key": crawlKey,
+			"dao":       "token_recovery",
+		}).Error("Error to clear the page token")
+	}
+
+	return err
+}
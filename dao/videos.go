@@ -1,6 +1,8 @@
 package dao
 
 import (
+	"database/sql"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -8,7 +10,9 @@ import (
 )
 
 // VideosService service
-type VideosService struct{}
+type VideosService struct {
+	db *sql.DB
+}
 
 // Videos model
 type Videos struct {
@@ -19,15 +23,14 @@ type Videos struct {
 }
 
 // NewVideosService new service
-func NewVideosService() VideosService {
-	return VideosService{}
+func NewVideosService(db *sql.DB) VideosService {
+	return VideosService{db: db}
 }
 
 // Index from database
 func (s VideosService) Index() []Videos {
 
-	// start connection
-	db := dbConn()
+	db := s.db
 
 	selSQL, err := db.Query("SELECT * FROM videos")
 
@@ -36,8 +39,12 @@ func (s VideosService) Index() []Videos {
 			"err": err.Error(),
 			"dao": "videos",
 		}).Error("Error to retrieve data")
+
+		return []Videos{}
 	}
 
+	defer selSQL.Close()
+
 	video := Videos{}
 	res := []Videos{}
 
@@ -61,11 +68,10 @@ func (s VideosService) Index() []Videos {
 		video.ID = id
 		video.VideoID = vID
 		video.ChannelID = cID
+		video.InsertedAt = insertAt
 		res = append(res, video)
 	}
 
-	defer db.Close()
-
 	return res
 
 }
@@ -73,19 +79,22 @@ func (s VideosService) Index() []Videos {
 // Show by id
 func (s VideosService) Show(videoID string) Videos {
 
-	// start connection
-	db := dbConn()
+	db := s.db
 
-	selSQL, err := db.Query("SELECT * FROM videos WHERE id=?", videoID)
+	selSQL, err := db.Query("SELECT * FROM videos WHERE video_id=?", videoID)
 
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
-			"err": err.Error(),
-			"id":  videoID,
-			"dao": "videos",
+			"err":      err.Error(),
+			"video_id": videoID,
+			"dao":      "videos",
 		}).Error("Error to retrieve by ID")
+
+		return Videos{}
 	}
 
+	defer selSQL.Close()
+
 	video := Videos{}
 
 	for selSQL.Next() {
@@ -108,18 +117,64 @@ func (s VideosService) Show(videoID string) Videos {
 		video.ID = id
 		video.VideoID = vID
 		video.ChannelID = cID
+		video.InsertedAt = insertAt
 	}
 
-	defer db.Close()
-
 	return video
 
 }
 
+// Existing returns which of the video IDs are already in the database, in a single query
+func (s VideosService) Existing(videoIDs []string) (map[string]bool, error) {
+
+	res := map[string]bool{}
+
+	if len(videoIDs) <= 0 {
+		return res, nil
+	}
+
+	db := s.db
+
+	args := make([]interface{}, len(videoIDs))
+	for i, id := range videoIDs {
+		args[i] = id
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(videoIDs)), ",")
+
+	selSQL, err := db.Query("SELECT video_id FROM videos WHERE video_id IN ("+placeholders+")", args...)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err": err.Error(),
+			"dao": "videos",
+		}).Error("Error to retrieve the existing videos")
+
+		return res, err
+	}
+
+	defer selSQL.Close()
+
+	for selSQL.Next() {
+
+		var vID string
+
+		err = selSQL.Scan(&vID)
+
+		if err != nil {
+			return res, err
+		}
+
+		res[vID] = true
+	}
+
+	return res, selSQL.Err()
+}
+
 // Insert blacklist
 func (s VideosService) Insert(videos Videos) error {
 
-	db := dbConn()
+	db := s.db
 
 	insForm, err := db.Prepare("INSERT INTO videos(video_id, channel_id) VALUES(?,?)")
 
@@ -132,9 +187,9 @@ func (s VideosService) Insert(videos Videos) error {
 		return err
 	}
 
-	insForm.Exec(videos.VideoID, videos.ChannelID)
+	defer insForm.Close()
 
-	defer db.Close()
+	insForm.Exec(videos.VideoID, videos.ChannelID)
 
 	return nil
 }
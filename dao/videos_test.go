@@ -0,0 +1,80 @@
+package dao_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	"soliveboa/youtuber/v2/dao"
+)
+
+// videosDriver is a database with a single stored video, the rows come as the mysql driver sends them
+type videosDriver struct {
+	insertAt time.Time
+}
+
+func (d videosDriver) Open(name string) (driver.Conn, error) { return videosConn(d), nil }
+
+type videosConn videosDriver
+
+func (c videosConn) Prepare(query string) (driver.Stmt, error) { return videosStmt(c), nil }
+func (c videosConn) Close() error                              { return nil }
+func (c videosConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+type videosStmt videosConn
+
+func (s videosStmt) Close() error                                    { return nil }
+func (s videosStmt) NumInput() int                                   { return -1 }
+func (s videosStmt) Exec(args []driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (s videosStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &videosRows{insertAt: s.insertAt}, nil
+}
+
+type videosRows struct {
+	insertAt time.Time
+	read     bool
+}
+
+func (r *videosRows) Columns() []string { return []string{"id", "video_id", "channel_id", "insert_at"} }
+func (r *videosRows) Close() error      { return nil }
+func (r *videosRows) Next(dest []driver.Value) error {
+
+	if r.read {
+		return io.EOF
+	}
+
+	r.read = true
+	dest[0], dest[1], dest[2], dest[3] = int64(1), "video-aaaaaa", "UC-aaaaaa", r.insertAt
+
+	return nil
+}
+
+func TestVideosKeepTheInsertDate(t *testing.T) {
+
+	insertAt := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+
+	sql.Register("videos-test", videosDriver{insertAt: insertAt})
+
+	db, err := sql.Open("videos-test", "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	videos := dao.NewVideosService(db)
+
+	// what "video inspect" shows as the collected date
+	if got := videos.Show("video-aaaaaa").InsertedAt; !got.Equal(insertAt) {
+		t.Errorf("Show().InsertedAt = %v, want %v", got, insertAt)
+	}
+
+	list := videos.Index()
+
+	if len(list) != 1 || !list[0].InsertedAt.Equal(insertAt) {
+		t.Errorf("Index() = %v, want one video inserted at %v", list, insertAt)
+	}
+}
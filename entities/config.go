@@ -0,0 +1,548 @@
+package entities
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigEnv is the environment variable with the path of the settings file
+const ConfigEnv = "YOUTUBER_CONFIG"
+
+// DefaultConfigPath is used when neither the flag nor the environment define the path
+const DefaultConfigPath = "settings.json"
+
+// envPrefix of the variables that override the settings, like YOUTUBER_RABBIT_HOSTNAME
+const envPrefix = "YOUTUBER"
+
+var (
+	settingsMu   sync.RWMutex
+	dataSettings Settings
+)
+
+// ConfigPath returns the path of the settings file: the flag, then the environment, then the default
+func ConfigPath(flagValue string) string {
+
+	if flagValue != "" {
+		return flagValue
+	}
+
+	if env := os.Getenv(ConfigEnv); env != "" {
+		return env
+	}
+
+	return DefaultConfigPath
+}
+
+// Load reads the settings file, applies the environment overrides and validates the result.
+// The settings in use are only replaced when the new ones are valid
+func Load(path string) error {
+
+	s, err := Read(path)
+
+	if err != nil {
+		return err
+	}
+
+	settingsMu.Lock()
+	dataSettings = s
+	settingsMu.Unlock()
+
+	return nil
+}
+
+// Read reads, overrides and validates the settings file without using it
+func Read(path string) (Settings, error) {
+
+	var s Settings
+
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return s, fmt.Errorf("error to read the settings file: %v", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(&s)
+
+	if err != nil {
+		return s, fmt.Errorf("error to parse the settings file %s: %v", path, err)
+	}
+
+	err = applyEnv(reflect.ValueOf(&s).Elem(), envPrefix)
+
+	if err != nil {
+		return s, err
+	}
+
+	err = resolveSecrets(reflect.ValueOf(&s).Elem(), "")
+
+	if err != nil {
+		return s, err
+	}
+
+	problems := s.Validate()
+
+	if len(problems) > 0 {
+		return s, fmt.Errorf("invalid settings in %s:\n  - %s", path, strings.Join(problems, "\n  - "))
+	}
+
+	return s, nil
+}
+
+// Current returns the settings in use
+func Current() Settings {
+	return current()
+}
+
+func current() Settings {
+
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+
+	return dataSettings
+}
+
+// applyEnv overrides each field by the variable named after its json path,
+// like YOUTUBER_LIST_MAX_RESULTS. Lists of strings are comma separated.
+// Lists of objects and maps can only be set in the file
+func applyEnv(v reflect.Value, prefix string) error {
+
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+
+		if tag == "" || tag == "-" {
+			continue
+		}
+
+		name := prefix + "_" + envName(tag)
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+
+		if !ok {
+			continue
+		}
+
+		err := setField(field, value)
+
+		if err != nil {
+			return fmt.Errorf("invalid value of %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(n)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(b)
+
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return errors.New("only set in the settings file")
+		}
+
+		items := []string{}
+
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+
+		field.Set(reflect.ValueOf(items))
+
+	default:
+		return errors.New("only set in the settings file")
+	}
+
+	return nil
+}
+
+// secretRef matches the ${VAR} references inside the values
+var secretRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// filePrefix - the value is read from the file, like the secrets mounted by docker or kubernetes
+const filePrefix = "file:"
+
+// resolveSecrets replaces the references in all the texts of the settings:
+// "${VAR}" is replaced by the environment variable (also inside a text, like a dsn)
+// and "file:/path" by the content of the file. Missing references are errors.
+// The values are never part of the errors
+func resolveSecrets(v reflect.Value, path string) error {
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+
+		for i := 0; i < t.NumField(); i++ {
+
+			tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+
+			if tag == "" || tag == "-" {
+				continue
+			}
+
+			name := tag
+			if path != "" {
+				name = path + "." + tag
+			}
+
+			if err := resolveSecrets(v.Field(i), name); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveSecrets(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.String:
+		value, err := resolveSecret(v.String())
+
+		if err != nil {
+			return fmt.Errorf("error to resolve %s: %v", path, err)
+		}
+
+		v.SetString(value)
+	}
+
+	return nil
+}
+
+func resolveSecret(value string) (string, error) {
+
+	if strings.HasPrefix(value, filePrefix) {
+
+		content, err := ioutil.ReadFile(strings.TrimPrefix(value, filePrefix))
+
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+
+	var missing []string
+
+	res := secretRef.ReplaceAllStringFunc(value, func(ref string) string {
+
+		name := secretRef.FindStringSubmatch(ref)[1]
+		env, ok := os.LookupEnv(name)
+
+		if !ok {
+			missing = append(missing, name)
+		}
+
+		return env
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+
+	return res, nil
+}
+
+// envName turns the json name into the variable name: maxResults -> MAX_RESULTS
+func envName(tag string) string {
+
+	var b strings.Builder
+
+	for i, r := range tag {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteRune('_')
+		}
+
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
+
+// Values accepted by the youtube api
+var (
+	eventTypes   = []string{"completed", "live", "upcoming"}
+	orders       = []string{"date", "rating", "relevance", "title", "videoCount", "viewCount"}
+	videoTypes   = []string{"video", "channel", "playlist"}
+	channelModes = []string{ChannelModeSearch, ChannelModeUploads}
+	dbDrivers    = []string{DatabaseDriverMySQL, DatabaseDriverMemory}
+)
+
+// Validate returns all the problems found in the settings
+func (s Settings) Validate() []string {
+
+	var problems []string
+
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// list
+	if s.List.MaxResults < 1 || s.List.MaxResults > 50 {
+		fail("list.maxResults must be between 1 and 50, got %d", s.List.MaxResults)
+	}
+
+	if s.List.Part == "" {
+		fail("list.part is required")
+	}
+
+	if !oneOf(s.List.EventType, eventTypes, true) {
+		fail("list.eventType %q is unknown, use one of %s", s.List.EventType, strings.Join(eventTypes, ", "))
+	}
+
+	if !oneOf(s.List.Order, orders, true) {
+		fail("list.order %q is unknown, use one of %s", s.List.Order, strings.Join(orders, ", "))
+	}
+
+	if !oneOf(s.List.VideoType, videoTypes, true) {
+		fail("list.videoType %q is unknown, use one of %s", s.List.VideoType, strings.Join(videoTypes, ", "))
+	}
+
+	if s.List.PublishedAfter != "" {
+		if _, err := time.Parse(time.RFC3339, s.List.PublishedAfter); err != nil {
+			fail("list.publishedAfter must be a RFC3339 date, got %q", s.List.PublishedAfter)
+		}
+	}
+
+	if s.Video.Part == "" {
+		fail("video.part is required")
+	}
+
+	// rabbit
+	if s.Rabbit.Hostname == "" {
+		fail("rabbit.hostname is required")
+	}
+
+	if _, err := strconv.Atoi(s.Rabbit.Port); err != nil {
+		fail("rabbit.port must be a number, got %q", s.Rabbit.Port)
+	}
+
+	if s.Rabbit.ChannelPool < 0 {
+		fail("rabbit.channelPool can't be negative")
+	}
+
+	// web server
+	if s.WebServer.BaseURL == "" {
+		fail("webServer.baseUrl is required")
+	}
+
+	// database
+	if !oneOf(s.Database.Driver, dbDrivers, true) {
+		fail("database.driver %q is unknown, use one of %s", s.Database.Driver, strings.Join(dbDrivers, ", "))
+	}
+
+	if s.Database.Driver != DatabaseDriverMemory && s.Database.DSN == "" {
+		fail("database.dsn is required")
+	}
+
+	validDuration(s.Database.ConnMaxLifetime, "database.connMaxLifetime", fail)
+
+	// crawls
+	if !oneOf(s.Channels.Mode, channelModes, true) {
+		fail("channels.mode %q is unknown, use one of %s", s.Channels.Mode, strings.Join(channelModes, ", "))
+	}
+
+	if s.Quota.DailyLimit < 0 {
+		fail("quota.dailyLimit can't be negative")
+	}
+
+	locations := map[string]bool{}
+
+	for i, l := range s.Locations {
+
+		if l.Name == "" || locations[l.Name] {
+			fail("locations[%d].name is required and must be unique", i)
+		}
+
+		locations[l.Name] = true
+
+		if !validCoordenates(l.Coordenates) {
+			fail("locations[%d].coordenates must be \"latitude,longitude\", got %q", i, l.Coordenates)
+		}
+
+		if l.Radius == "" {
+			fail("locations[%d].radius is required", i)
+		}
+	}
+
+	queries := map[string]bool{}
+
+	for i, q := range s.Queries {
+
+		if q.Name == "" || queries[q.Name] {
+			fail("queries[%d].name is required and must be unique", i)
+		}
+
+		queries[q.Name] = true
+
+		if q.Query == "" {
+			fail("queries[%d].query is required", i)
+		}
+
+		if !oneOf(q.EventType, eventTypes, true) {
+			fail("queries[%d].eventType %q is unknown, use one of %s", i, q.EventType, strings.Join(eventTypes, ", "))
+		}
+
+		validSchedule(q.Schedule, fmt.Sprintf("queries[%d].schedule", i), fail)
+	}
+
+	// consumer and daemon
+	for i, d := range s.Consumer.RetryDelays {
+		validDuration(d, fmt.Sprintf("consumer.retryDelays[%d]", i), fail)
+	}
+
+	if s.Consumer.Workers < 0 || s.Consumer.Prefetch < 0 {
+		fail("consumer.workers and consumer.prefetch can't be negative")
+	}
+
+	for name, schedule := range s.Daemon.Jobs {
+		validSchedule(schedule, "daemon.jobs."+name, fail)
+	}
+
+	validDuration(s.Shutdown, "shutdownTimeout", fail)
+
+	// logs
+	if s.Log.Level != "" {
+		if _, err := logrus.ParseLevel(s.Log.Level); err != nil {
+			fail("log.level %q is unknown, use one of trace, debug, info, warning, error", s.Log.Level)
+		}
+	}
+
+	if !oneOf(s.Log.Format, []string{LogFormatJSON, LogFormatText}, true) {
+		fail("log.format %q is unknown, use json or text", s.Log.Format)
+	}
+
+	if !oneOf(s.Log.Output, []string{LogOutputStdout, LogOutputFile}, true) {
+		fail("log.output %q is unknown, use stdout or file", s.Log.Output)
+	}
+
+	if s.Log.Output == LogOutputFile && s.Log.File == "" {
+		fail("log.file is required when log.output is file")
+	}
+
+	if s.Log.MaxSizeMB < 0 || s.Log.MaxAgeDays < 0 || s.Log.MaxBackups < 0 {
+		fail("log.maxSizeMb, log.maxAgeDays and log.maxBackups can't be negative")
+	}
+
+	return problems
+}
+
+// oneOf checks the value is in the list. Empty is accepted when optional
+func oneOf(value string, list []string, optional bool) bool {
+
+	if value == "" {
+		return optional
+	}
+
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validDuration checks the optional duration
+func validDuration(value string, name string, fail func(string, ...interface{})) {
+
+	if value == "" {
+		return
+	}
+
+	if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+		fail("%s must be a duration like \"30s\" or \"5m\", got %q", name, value)
+	}
+}
+
+// validSchedule checks the optional schedule has only one valid interval or cron expression
+func validSchedule(s ScheduleSettings, name string, fail func(string, ...interface{})) {
+
+	if s.Interval != "" && s.Cron != "" {
+		fail("%s must have either interval or cron, not both", name)
+		return
+	}
+
+	if s.Interval != "" {
+		if d, err := time.ParseDuration(s.Interval); err != nil || d < time.Minute {
+			fail("%s.interval must be a duration of at least 1m, got %q", name, s.Interval)
+		}
+	}
+
+	if s.Cron != "" {
+		if _, err := cron.ParseStandard(s.Cron); err != nil {
+			fail("%s.cron %q is invalid: %v", name, s.Cron, err)
+		}
+	}
+}
+
+// validCoordenates checks the "latitude,longitude" pair
+func validCoordenates(value string) bool {
+
+	parts := strings.Split(value, ",")
+
+	if len(parts) != 2 {
+		return false
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+
+	if err != nil || lat < -90 || lat > 90 {
+		return false
+	}
+
+	lng, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+
+	return err == nil && lng >= -180 && lng <= 180
+}
@@ -0,0 +1,200 @@
+package entities
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+// setEnv sets the variables for the test and restores them after it. An empty value unsets the variable
+func setEnv(t *testing.T, vars map[string]string) {
+
+	t.Helper()
+
+	for name, value := range vars {
+
+		old, ok := os.LookupEnv(name)
+
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+
+		n := name
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(n, old)
+			} else {
+				os.Unsetenv(n)
+			}
+		})
+	}
+}
+
+// validSettings reads the settings shipped with the repo, they must be valid
+func validSettings(t *testing.T) Settings {
+
+	t.Helper()
+
+	setEnv(t, map[string]string{
+		"RABBIT_USER": "guest",
+		"RABBIT_PASS": "guest",
+		"MYSQL_DSN":   "user:pass@tcp(localhost:3306)/youtuber",
+	})
+
+	s, err := Read(filepath.Join("..", DefaultConfigPath))
+
+	if err != nil {
+		t.Fatalf("the settings of the repo are invalid: %v", err)
+	}
+
+	return s
+}
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		change func(s *Settings)
+		want   string
+	}{
+		{"valid", func(s *Settings) {}, ""},
+		{"maxResults too big", func(s *Settings) { s.List.MaxResults = 51 }, "list.maxResults must be between 1 and 50"},
+		{"unknown event type", func(s *Settings) { s.List.EventType = "soon" }, `list.eventType "soon" is unknown`},
+		{"publishedAfter not RFC3339", func(s *Settings) { s.List.PublishedAfter = "2020-01-01" }, "list.publishedAfter must be a RFC3339 date"},
+		{"port not a number", func(s *Settings) { s.Rabbit.Port = "amqp" }, `rabbit.port must be a number, got "amqp"`},
+		{"mysql without dsn", func(s *Settings) { s.Database.DSN = "" }, "database.dsn is required"},
+		{"memory without dsn", func(s *Settings) { s.Database.Driver = DatabaseDriverMemory; s.Database.DSN = "" }, ""},
+		{"unknown channel mode", func(s *Settings) { s.Channels.Mode = "fast" }, `channels.mode "fast" is unknown`},
+		{"duplicated location", func(s *Settings) { s.Locations = append(s.Locations, s.Locations[0]) }, "locations[4].name is required and must be unique"},
+		{"bad coordenates", func(s *Settings) { s.Locations[0].Coordenates = "north" }, `locations[0].coordenates must be "latitude,longitude"`},
+		{"query without text", func(s *Settings) { s.Queries[0].Query = "" }, "queries[0].query is required"},
+		{"bad retry delay", func(s *Settings) { s.Consumer.RetryDelays = []string{"soon"} }, "consumer.retryDelays[0]"},
+		{"interval and cron", func(s *Settings) {
+			s.Daemon.Jobs["playlists"] = ScheduleSettings{Interval: "1h", Cron: "0 * * * *"}
+		}, "daemon.jobs.playlists"},
+		{"unknown log level", func(s *Settings) { s.Log.Level = "loud" }, `log.level "loud" is unknown`},
+		{"log file without path", func(s *Settings) { s.Log.Output = LogOutputFile; s.Log.File = "" }, "log.file is required when log.output is file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			s := validSettings(t)
+			tt.change(&s)
+
+			problems := s.Validate()
+
+			if tt.want == "" {
+				if len(problems) > 0 {
+					t.Fatalf("Validate() = %v, want no problems", problems)
+				}
+
+				return
+			}
+
+			for _, p := range problems {
+				if strings.Contains(p, tt.want) {
+					return
+				}
+			}
+
+			t.Errorf("Validate() = %v, want a problem with %q", problems, tt.want)
+		})
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+
+	setEnv(t, map[string]string{
+		"YT_TEST_USER": "admin",
+		"YT_TEST_PASS": "s3cr3t",
+	})
+
+	dir, err := ioutil.TempDir("", "secrets")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "api_key")
+	ioutil.WriteFile(secretFile, []byte("AIza-file-key\n"), 0600)
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{"plain text", "localhost", "localhost", ""},
+		{"variable", "${YT_TEST_USER}", "admin", ""},
+		{"variables inside a text", "${YT_TEST_USER}:${YT_TEST_PASS}@tcp(db)/yt", "admin:s3cr3t@tcp(db)/yt", ""},
+		{"missing variable", "${YT_TEST_MISSING}", "", "environment variable YT_TEST_MISSING is not set"},
+		{"file without the new line", "file:" + secretFile, "AIza-file-key", ""},
+		{"missing file", "file:" + filepath.Join(dir, "nothing"), "", "no such file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got, err := resolveSecret(tt.value)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveSecret(%q) error = %v, want %q", tt.value, err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("resolveSecret(%q) error = %v", tt.value, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("resolveSecret(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRabbitConnString(t *testing.T) {
+
+	rabbit := RabbitSettings{Hostname: "broker", Port: "5672", User: "collector", Pass: "p@ss:w/rd#1"}
+
+	uri, err := amqp.ParseURI(rabbit.ConnString())
+
+	if err != nil {
+		t.Fatalf("ParseURI(%q) error = %v", rabbit.ConnString(), err)
+	}
+
+	if uri.Username != rabbit.User || uri.Password != rabbit.Pass || uri.Host != "broker" || uri.Port != 5672 {
+		t.Errorf("ParseURI(%q) = %+v, want the settings back", rabbit.ConnString(), uri)
+	}
+}
+
+func TestReadNeverShowsTheSecret(t *testing.T) {
+
+	setEnv(t, map[string]string{
+		"RABBIT_USER": "guest",
+		"RABBIT_PASS": "",
+		"MYSQL_DSN":   "user:very-secret@tcp(localhost:3306)/youtuber",
+	})
+
+	_, err := Read(filepath.Join("..", DefaultConfigPath))
+
+	if err == nil {
+		t.Fatal("Read() without RABBIT_PASS must fail")
+	}
+
+	if !strings.Contains(err.Error(), "rabbit.") || strings.Contains(err.Error(), "very-secret") {
+		t.Errorf("Read() error = %q, want the setting path and no secret value", err)
+	}
+}
@@ -1,9 +1,9 @@
 package entities
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"os"
+	"net"
+	"net/url"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -16,8 +16,17 @@ type Settings struct {
 	Auth       []string          `json:"auth"`
 	Categories []string          `json:"categories"`
 	Playlists  []string          `json:"playlists"`
+	Locations  []Location        `json:"locations"`
+	Queries    []Query           `json:"queries"`
 	Rabbit     RabbitSettings    `json:"rabbit"`
-	WebServer  WebServerSettings `json:"webServer`
+	WebServer  WebServerSettings `json:"webServer"`
+	Quota      QuotaSettings     `json:"quota"`
+	Channels   ChannelParameters `json:"channels"`
+	Database   DatabaseSettings  `json:"database"`
+	Consumer   ConsumerSettings  `json:"consumer"`
+	Shutdown   string            `json:"shutdownTimeout"`
+	Daemon     DaemonSettings    `json:"daemon"`
+	Log        LogSettings       `json:"log"`
 }
 
 // ListParameters - Define the parameters to return the list
@@ -36,6 +45,35 @@ type ListParameters struct {
 	LocationRadius string `json:"locationRadius"`
 }
 
+// SearchPublishedAfter returns the publishedAfter of the searches, the last day when it is not set
+func (p ListParameters) SearchPublishedAfter() string {
+
+	if p.PublishedAfter != "" {
+		return p.PublishedAfter
+	}
+
+	return time.Now().AddDate(0, 0, -1).Format(time.RFC3339)
+}
+
+// Location - area swept by the location search. Coordenates is "latitude,longitude"
+// and Radius is the distance around it, like "1000km"
+type Location struct {
+	Name        string `json:"name"`
+	Coordenates string `json:"coordenates"`
+	Radius      string `json:"radius"`
+}
+
+// Query - keyword search run as its own job, on its own schedule.
+// Empty parameters use the ones from the list settings
+type Query struct {
+	Name       string           `json:"name"`
+	Query      string           `json:"query"`
+	RegionCode string           `json:"regionCode"`
+	Language   string           `json:"language"`
+	EventType  string           `json:"eventType"`
+	Schedule   ScheduleSettings `json:"schedule"`
+}
+
 // VideoParameters - Define the parameters to return vide
 type VideoParameters struct {
 	Part string `json:"part"`
@@ -43,10 +81,11 @@ type VideoParameters struct {
 
 // RabRabbitSettings model
 type RabbitSettings struct {
-	Hostname string `json:"hostname"`
-	Port     string `json:"port"`
-	User     string `json:"user"`
-	Pass     string `json:"pass"`
+	Hostname    string `json:"hostname"`
+	Port        string `json:"port"`
+	User        string `json:"user"`
+	Pass        string `json:"pass"`
+	ChannelPool int    `json:"channelPool"`
 }
 
 type WebServerSettings struct {
@@ -58,106 +97,253 @@ type WebServerEndpoints struct {
 	Channels string `json:"channels"`
 }
 
-var dataSettings Settings
+// QuotaSettings - Define the youtube api quota available per key
+type QuotaSettings struct {
+	DailyLimit int64 `json:"dailyLimit"`
+}
 
-func loadData() {
-	jsonFile, err := os.Open("settings.json")
-	// if we os.Open returns an error then handle it
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"file":  "settings.json",
-			"error": err.Error(),
-		}).Error("Error to open settings.json file")
+// Channel crawl modes
+const (
+	ChannelModeSearch  = "search"
+	ChannelModeUploads = "uploads"
+)
+
+// ChannelParameters - Define how the registered channels are crawled
+// Mode "search" uses search.list for every channel. Mode "uploads" reads the uploads playlist
+// of the fully synced channels, the search is kept only for the history backfill. The upcoming
+// events of these channels are left to the upcoming searches by location, query and category
+type ChannelParameters struct {
+	Mode                   string `json:"mode"`
+	IncrementalWindowHours int    `json:"incrementalWindowHours"`
+}
 
+// UploadsMode method
+func (c ChannelParameters) UploadsMode() bool {
+	return c.Mode == ChannelModeUploads
+}
+
+// IncrementalWindow returns how far back the fully synced channels are searched
+func (c ChannelParameters) IncrementalWindow() time.Duration {
+
+	if c.IncrementalWindowHours <= 0 {
+		return 24 * time.Hour
 	}
 
-	// defer the closing of our jsonFile so that we can parse it later on
-	defer jsonFile.Close()
+	return time.Duration(c.IncrementalWindowHours) * time.Hour
+}
 
-	// retrieve json settings
-	byteValue, err := ioutil.ReadAll(jsonFile)
+// Database drivers
+const (
+	DatabaseDriverMySQL  = "mysql"
+	DatabaseDriverMemory = "memory"
+)
+
+// DatabaseSettings - Define the mysql connection pool
+// Driver "memory" keeps everything in memory, nothing is persisted
+type DatabaseSettings struct {
+	Driver          string `json:"driver"`
+	DSN             string `json:"dsn"`
+	MaxOpenConns    int    `json:"maxOpenConns"`
+	MaxIdleConns    int    `json:"maxIdleConns"`
+	ConnMaxLifetime string `json:"connMaxLifetime"`
+}
 
-	// fmt.Println(string(byteValue))
+// Lifetime returns how long a connection may be reused. Zero means forever
+func (d DatabaseSettings) Lifetime() time.Duration {
+
+	lifetime, err := time.ParseDuration(d.ConnMaxLifetime)
 
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"file":  "settings.json",
-			"error": err.Error(),
-		}).Error("Error to read the file content")
+		return 0
 	}
 
-	jsonFile.Close()
+	return lifetime
+}
 
-	// var data Settings
+// ConsumerSettings - Define how the video consumer behaves
+// MaxReconnects is how many reconnections in a row are tried before the process exits.
+// A failed message is tried again after each one of the RetryDelays (the last one is repeated)
+// until it has been handled MaxAttempts times (the first one included), then it goes to the dead queue.
+// Workers is how many messages are handled at the same time, each worker receives up to Prefetch messages
+type ConsumerSettings struct {
+	MaxReconnects int      `json:"maxReconnects"`
+	MaxAttempts   int      `json:"maxAttempts"`
+	RetryDelays   []string `json:"retryDelays"`
+	Workers       int      `json:"workers"`
+	Prefetch      int      `json:"prefetch"`
+}
 
-	err = json.Unmarshal(byteValue, &dataSettings)
+// Delays returns the retry delays. Invalid values are ignored
+func (c ConsumerSettings) Delays() []time.Duration {
 
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err.Error(),
-		}).Error("Error to unmarshall data")
+	res := []time.Duration{}
+
+	for _, val := range c.RetryDelays {
+
+		d, err := time.ParseDuration(val)
+
+		if err != nil || d <= 0 {
+			logrus.WithFields(logrus.Fields{
+				"delay": val,
+			}).Warning("Invalid retry delay ignored")
+
+			continue
+		}
+
+		res = append(res, d)
 	}
+
+	return res
+}
+
+// ScheduleSettings - when a job of the daemon runs: every Interval ("30m")
+// or on the Cron expression ("0 */2 * * *"), only one of them
+type ScheduleSettings struct {
+	Interval string `json:"interval"`
+	Cron     string `json:"cron"`
+}
+
+// DaemonSettings - the schedule of the daemon jobs by their name.
+// The jobs not listed don't run
+type DaemonSettings struct {
+	Jobs map[string]ScheduleSettings `json:"jobs"`
 }
 
+// Log outputs and formats
+const (
+	LogOutputStdout = "stdout"
+	LogOutputFile   = "file"
+	LogFormatJSON   = "json"
+	LogFormatText   = "text"
+)
+
+// LogSettings - Define the logs. Level is trace, debug, info, warning or error (default info).
+// Format is json (default) or text. Output is stdout (default) or file, the file is rotated
+// when it reaches MaxSizeMB, and the old ones are removed after MaxAgeDays or MaxBackups
+type LogSettings struct {
+	Level      string `json:"level"`
+	Format     string `json:"format"`
+	Output     string `json:"output"`
+	File       string `json:"file"`
+	MaxSizeMB  int    `json:"maxSizeMb"`
+	MaxAgeDays int    `json:"maxAgeDays"`
+	MaxBackups int    `json:"maxBackups"`
+}
+
+// defaultShutdownTimeout - used when the settings don't define a valid shutdownTimeout
+const defaultShutdownTimeout = 30 * time.Second
+
 // GeGetEnv method
 func GetEnv() string {
-	loadData()
-	return dataSettings.Env
+	return current().Env
 }
 
 // GetParametersList method
 func GetParametersList() ListParameters {
-
-	loadData()
-	return dataSettings.List
+	return current().List
 }
 
 // GetParametersVideo method
 func GetParametersVideo() VideoParameters {
-
-	loadData()
-	return dataSettings.Video
+	return current().Video
 }
 
 // GetAuthKeys method
 func GetAuthKeys() []string {
-	loadData()
-	return dataSettings.Auth
+	return current().Auth
 }
 
 // GetGetCategories method
 func GetCategories() []string {
-	loadData()
-	return dataSettings.Categories
+	return current().Categories
 }
 
 // GetGetPlaylists methodo
 func GetPlaylists() []string {
-	loadData()
-	return dataSettings.Playlists
+	return current().Playlists
+}
+
+// GetLocations method
+func GetLocations() []Location {
+	return current().Locations
+}
+
+// GetQueries method
+func GetQueries() []Query {
+	return current().Queries
 }
 
 // GetGetRabbitSettings method
 func GetRabbitSettings() RabbitSettings {
-	loadData()
-	return dataSettings.Rabbit
+	return current().Rabbit
 }
 
 // getGetRabbitConnString method
 func GetRabbitConnString() string {
 
-	rabbit := GetRabbitSettings()
-	return "amqp://" + rabbit.User + ":" + rabbit.Pass + "@" + rabbit.Hostname + ":" + rabbit.Port
+	return GetRabbitSettings().ConnString()
+}
+
+// ConnString returns the amqp URI, the user and the password are escaped
+func (r RabbitSettings) ConnString() string {
+
+	uri := url.URL{
+		Scheme: "amqp",
+		User:   url.UserPassword(r.User, r.Pass),
+		Host:   net.JoinHostPort(r.Hostname, r.Port),
+	}
+
+	return uri.String()
 }
 
 // GetGetWebServer method
 func GetWebServer() WebServerSettings {
-
-	loadData()
-	return dataSettings.WebServer
+	return current().WebServer
 }
 
 // GetGetWebServerEndpoints method
 func GetWebServerEndpoints() WebServerEndpoints {
 	return GetWebServer().Endpoints
 }
+
+// GetQuotaSettings method
+func GetQuotaSettings() QuotaSettings {
+	return current().Quota
+}
+
+// GetChannelCrawlParameters method
+func GetChannelCrawlParameters() ChannelParameters {
+	return current().Channels
+}
+
+// GetDatabaseSettings method
+func GetDatabaseSettings() DatabaseSettings {
+	return current().Database
+}
+
+// GetConsumerSettings method
+func GetConsumerSettings() ConsumerSettings {
+	return current().Consumer
+}
+
+// GetDaemonSettings method
+func GetDaemonSettings() DaemonSettings {
+	return current().Daemon
+}
+
+// GetLogSettings method
+func GetLogSettings() LogSettings {
+	return current().Log
+}
+
+// GetShutdownTimeout returns how long the work in progress has to finish after a stop signal
+func GetShutdownTimeout() time.Duration {
+
+	timeout, err := time.ParseDuration(current().Shutdown)
+
+	if err != nil || timeout <= 0 {
+		return defaultShutdownTimeout
+	}
+
+	return timeout
+}
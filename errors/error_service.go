@@ -1,8 +1,6 @@
 package errors
 
 import (
-	"strings"
-
 	"github.com/sirupsen/logrus"
 )
 
@@ -26,17 +24,3 @@ func HandleError(message string, err error, fatal bool) bool {
 
 	return false
 }
-
-// VeryfyVerifyError403
-func VerifyError403(err error) (bool, error) {
-
-	if err != nil && strings.Contains(err.Error(), "403") {
-
-		logrus.Warning("Error 403")
-		return true, err
-	}
-
-	logrus.Error(err)
-	return false, err
-
-}
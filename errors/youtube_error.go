@@ -0,0 +1,162 @@
+package errors
+
+import (
+	goerrors "errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Kind of the error returned by the youtube api
+type Kind int
+
+// Kinds of youtube api errors handled by the service
+const (
+	KindUnknown Kind = iota
+	KindQuotaExceeded
+	KindRateLimited
+	KindKeyInvalid
+	KindAccessNotConfigured
+	KindForbidden
+	KindNotFound
+	KindInvalidPageToken
+	KindBadRequest
+	KindBackend
+)
+
+var kindNames = map[Kind]string{
+	KindUnknown:             "unknown",
+	KindQuotaExceeded:       "quotaExceeded",
+	KindRateLimited:         "rateLimited",
+	KindKeyInvalid:          "keyInvalid",
+	KindAccessNotConfigured: "accessNotConfigured",
+	KindForbidden:           "forbidden",
+	KindNotFound:            "notFound",
+	KindInvalidPageToken:    "invalidPageToken",
+	KindBadRequest:          "badRequest",
+	KindBackend:             "backend",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// reasonKinds maps the googleapi error reasons to the kind of the error
+var reasonKinds = map[string]Kind{
+	"quotaExceeded":              KindQuotaExceeded,
+	"dailyLimitExceeded":         KindQuotaExceeded,
+	"rateLimitExceeded":          KindRateLimited,
+	"userRateLimitExceeded":      KindRateLimited,
+	"keyInvalid":                 KindKeyInvalid,
+	"keyExpired":                 KindKeyInvalid,
+	"accessNotConfigured":        KindAccessNotConfigured,
+	"forbidden":                  KindForbidden,
+	"playlistItemsNotAccessible": KindForbidden,
+	"channelClosed":              KindForbidden,
+	"channelSuspended":           KindForbidden,
+	"notFound":                   KindNotFound,
+	"playlistNotFound":           KindNotFound,
+	"videoNotFound":              KindNotFound,
+	"channelNotFound":            KindNotFound,
+	"invalidPageToken":           KindInvalidPageToken,
+	"backendError":               KindBackend,
+	"internalError":              KindBackend,
+}
+
+// YoutubeError - error returned by the youtube api, classified by its cause
+type YoutubeError struct {
+	Kind      Kind
+	Status    int
+	Reason    string
+	Message   string
+	Retryable bool
+	Err       error
+}
+
+func (e *YoutubeError) Error() string {
+	return fmt.Sprintf("youtube api error %d (%s): %s", e.Status, e.Kind, e.Message)
+}
+
+// Unwrap returns the original error
+func (e *YoutubeError) Unwrap() error {
+	return e.Err
+}
+
+// KeyExhausted - the key has no more quota until the next reset
+func (e *YoutubeError) KeyExhausted() bool {
+	return e.Kind == KindQuotaExceeded
+}
+
+// KeyBroken - the key will not work until someone fixes it
+func (e *YoutubeError) KeyBroken() bool {
+	return e.Kind == KindKeyInvalid || e.Kind == KindAccessNotConfigured
+}
+
+// Classify reads the googleapi error and returns its cause. Returns nil when err is nil
+func Classify(err error) *YoutubeError {
+
+	if err == nil {
+		return nil
+	}
+
+	var yerr *YoutubeError
+	if goerrors.As(err, &yerr) {
+		return yerr
+	}
+
+	res := &YoutubeError{Kind: KindUnknown, Message: err.Error(), Err: err}
+
+	var gerr *googleapi.Error
+	if !goerrors.As(err, &gerr) {
+		// network failures may work on the next try
+		var nerr net.Error
+		if goerrors.As(err, &nerr) && nerr.Timeout() {
+			res.Retryable = true
+		}
+
+		return res
+	}
+
+	res.Status = gerr.Code
+	res.Message = gerr.Message
+
+	for _, item := range gerr.Errors {
+		if kind, ok := reasonKinds[item.Reason]; ok {
+			res.Kind = kind
+			res.Reason = item.Reason
+			break
+		}
+	}
+
+	if res.Kind == KindUnknown {
+		res.Kind = kindFromStatus(gerr.Code)
+
+		if len(gerr.Errors) > 0 {
+			res.Reason = gerr.Errors[0].Reason
+		}
+	}
+
+	res.Retryable = res.Kind == KindRateLimited || res.Kind == KindBackend
+
+	return res
+}
+
+func kindFromStatus(status int) Kind {
+
+	switch {
+	case status == http.StatusTooManyRequests:
+		return KindRateLimited
+	case status == http.StatusForbidden:
+		return KindForbidden
+	case status == http.StatusNotFound:
+		return KindNotFound
+	case status == http.StatusBadRequest:
+		return KindBadRequest
+	case status >= http.StatusInternalServerError:
+		return KindBackend
+	}
+
+	return KindUnknown
+}
@@ -0,0 +1,95 @@
+package errors
+
+import (
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+// timeoutError - a network error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func apiError(code int, reason string) error {
+
+	err := &googleapi.Error{Code: code, Message: reason}
+
+	if reason != "" {
+		err.Errors = []googleapi.ErrorItem{{Reason: reason}}
+	}
+
+	return err
+}
+
+func TestClassify(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		err           error
+		wantKind      Kind
+		wantStatus    int
+		wantRetryable bool
+		wantExhausted bool
+		wantBroken    bool
+	}{
+		{"quota exceeded", apiError(http.StatusForbidden, "quotaExceeded"), KindQuotaExceeded, 403, false, true, false},
+		{"daily limit", apiError(http.StatusForbidden, "dailyLimitExceeded"), KindQuotaExceeded, 403, false, true, false},
+		{"rate limited", apiError(http.StatusForbidden, "rateLimitExceeded"), KindRateLimited, 403, true, false, false},
+		{"key invalid", apiError(http.StatusBadRequest, "keyInvalid"), KindKeyInvalid, 400, false, false, true},
+		{"access not configured", apiError(http.StatusForbidden, "accessNotConfigured"), KindAccessNotConfigured, 403, false, false, true},
+		{"invalid page token", apiError(http.StatusBadRequest, "invalidPageToken"), KindInvalidPageToken, 400, false, false, false},
+		{"playlist not found", apiError(http.StatusNotFound, "playlistNotFound"), KindNotFound, 404, false, false, false},
+		{"unknown reason uses the status", apiError(http.StatusServiceUnavailable, "somethingNew"), KindBackend, 503, true, false, false},
+		{"too many requests without reason", apiError(http.StatusTooManyRequests, ""), KindRateLimited, 429, true, false, false},
+		{"wrapped api error", fmt.Errorf("page 3: %w", apiError(http.StatusForbidden, "quotaExceeded")), KindQuotaExceeded, 403, false, true, false},
+		{"network timeout", fmt.Errorf("get: %w", timeoutError{}), KindUnknown, 0, true, false, false},
+		{"any other error", goerrors.New("boom"), KindUnknown, 0, false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got := Classify(tt.err)
+
+			if got == nil {
+				t.Fatal("Classify() = nil")
+			}
+
+			if got.Kind != tt.wantKind || got.Status != tt.wantStatus || got.Retryable != tt.wantRetryable {
+				t.Errorf("Classify() = %s/%d retryable %v, want %s/%d retryable %v",
+					got.Kind, got.Status, got.Retryable, tt.wantKind, tt.wantStatus, tt.wantRetryable)
+			}
+
+			if got.KeyExhausted() != tt.wantExhausted || got.KeyBroken() != tt.wantBroken {
+				t.Errorf("KeyExhausted() = %v KeyBroken() = %v, want %v %v",
+					got.KeyExhausted(), got.KeyBroken(), tt.wantExhausted, tt.wantBroken)
+			}
+
+			if !goerrors.Is(got, tt.err) {
+				t.Error("the original error must be kept")
+			}
+		})
+	}
+}
+
+func TestClassifyNil(t *testing.T) {
+
+	if got := Classify(nil); got != nil {
+		t.Errorf("Classify(nil) = %v, want nil", got)
+	}
+}
+
+func TestClassifyKeepsClassified(t *testing.T) {
+
+	classified := Classify(apiError(http.StatusForbidden, "quotaExceeded"))
+
+	if got := Classify(fmt.Errorf("retry: %w", classified)); got != classified {
+		t.Errorf("Classify() = %v, want the error already classified", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"soliveboa/youtuber/v2/dao"
+	"soliveboa/youtuber/v2/entities"
+	_errors "soliveboa/youtuber/v2/errors"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+// startLocations sweeps all the locations from the settings
+func startLocations(ctx context.Context) {
+	sweepLocations(ctx, entities.GetLocations())
+}
+
+// sweepLocations runs the upcoming search once per location
+func sweepLocations(ctx context.Context, locations []entities.Location) {
+
+	logFrom(ctx).Info("[  *  ] Searching for upcoming videos by location ...")
+
+	for _, val := range locations {
+
+		// stop before the next location when the service is shutting down
+		if ctx.Err() != nil {
+			return
+		}
+
+		location := val
+
+		err := callWithKey(ctx, dao.QuotaCostSearch, func(key string) error {
+			return NewYotubeService().RunByLocation(ctx, key, location)
+		})
+
+		if err == dao.ErrNoKeyAvailable {
+			failOnError(err, "error to get key from list")
+			break
+		}
+
+		if err != nil && err != errNullPages {
+			_errors.HandleError("Error to retrieve data from location", err, false)
+		}
+	}
+}
+
+// RunByLocation - retrieve the upcoming videos around the location. Each location has its own checkpoint
+func (y Youtube) RunByLocation(ctx context.Context, k string, location entities.Location) error {
+
+	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(k))
+
+	if err != nil {
+		return err
+	}
+
+	pl := entities.GetParametersList()
+
+	// the location search only works with the video type
+	call := youtubeService.Search.List(pl.Part)
+	call.Type("video")
+	call.Location(location.Coordenates)
+	call.LocationRadius(location.Radius)
+	call.EventType(pl.EventType)
+	call.MaxResults(pl.MaxResults)
+	call.RelevanceLanguage(pl.Language)
+	call.PublishedAfter(pl.SearchPublishedAfter())
+	call.Order(pl.Order)
+
+	return runSearch(ctx, k, call, "location:"+location.Name, location.Name)
+}
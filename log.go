@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"soliveboa/youtuber/v2/entities"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logKey - key of the logger in the context
+type logKey struct{}
+
+// runID identifies all the logs of this process
+var runID = uuid.New().String()
+
+// logInit configures the logs from the settings: json or text, on stdout or on a rotated file.
+// Every entry has the run_id of the process
+func logInit() {
+
+	settings := entities.GetLogSettings()
+
+	var formatter logrus.Formatter = &logrus.JSONFormatter{}
+
+	if settings.Format == entities.LogFormatText {
+		formatter = &logrus.TextFormatter{
+			TimestampFormat: "02-01-2006 15:04:05",
+			FullTimestamp:   true,
+		}
+	}
+
+	logrus.SetFormatter(runFormatter{formatter})
+
+	if settings.Output == entities.LogOutputFile {
+		logrus.SetOutput(&lumberjack.Logger{
+			Filename:   settings.File,
+			MaxSize:    settings.MaxSizeMB,
+			MaxAge:     settings.MaxAgeDays,
+			MaxBackups: settings.MaxBackups,
+		})
+	} else {
+		logrus.SetOutput(os.Stdout)
+	}
+
+	setLogLevel()
+
+	// the level can be changed without a restart
+	onSettingsReload(setLogLevel)
+}
+
+// setLogLevel uses the level from the settings, info when it is empty
+func setLogLevel() {
+
+	level, err := logrus.ParseLevel(entities.GetLogSettings().Level)
+
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+
+	logrus.SetLevel(level)
+}
+
+// runFormatter adds the run_id to all the entries. The fields are copied, the entries
+// of a context are shared by the goroutines of the job or the message
+type runFormatter struct {
+	logrus.Formatter
+}
+
+func (f runFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+
+	data := make(logrus.Fields, len(entry.Data)+1)
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+
+	data["run_id"] = runID
+
+	e := *entry
+	e.Data = data
+
+	return f.Formatter.Format(&e)
+}
+
+// withLog returns a context whose logger has the fields added to the ones already in the context
+func withLog(ctx context.Context, fields logrus.Fields) context.Context {
+	return context.WithValue(ctx, logKey{}, logFrom(ctx).WithFields(fields))
+}
+
+// logFrom returns the logger of the context, with the fields of the job or the message in progress
+func logFrom(ctx context.Context) *logrus.Entry {
+
+	if log, ok := ctx.Value(logKey{}).(*logrus.Entry); ok {
+		return log
+	}
+
+	return logrus.NewEntry(logrus.StandardLogger())
+}
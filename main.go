@@ -1,198 +1,285 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"os"
+	"os/signal"
 	"soliveboa/youtuber/v2/dao"
+	"soliveboa/youtuber/v2/dao/memory"
 	"soliveboa/youtuber/v2/entities"
 	_errors "soliveboa/youtuber/v2/errors"
+	"soliveboa/youtuber/v2/rabbit"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 )
 
 var (
-	logPath      = "log_youtuber.log"
-	environment  = "development"
-	authKeys     []string
-	amqURL       string
-	webserver    string
-	endpoints    entities.WebServerEndpoints
-	nonFlag      = false
-	flagPlaylist bool
-	flagSearcher bool
-	flagVideo    bool
+	database     *sql.DB
+	repositories dao.Repositories
+	keyPool      *dao.KeyPool
+	broker       *rabbit.ServiceCall
+	configFlag   string
 )
 
 func main() {
 
+	global := flag.NewFlagSet(appName, flag.ContinueOnError)
+	global.StringVar(&configFlag, "config", "", "path of the settings file (default $"+entities.ConfigEnv+" or "+entities.DefaultConfigPath+")")
+	global.Usage = func() { printCommands(appName, commands) }
+
+	err := global.Parse(os.Args[1:])
+
+	switch {
+	case err == nil:
+		err = dispatch(appName, commands, global.Args())
+	case err != flag.ErrHelp:
+		err = errUsage
+	}
+
+	switch {
+	case err == nil || err == flag.ErrHelp:
+	case err == errUsage:
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "--> "+err.Error())
+		os.Exit(1)
+	}
+}
+
+// runService starts the service and runs the job until it finishes or the service is stopped
+func runService(job func(ctx context.Context)) {
+
+	// the logs are ready before anything else
+	logInit()
+
 	// init the service
 	initService()
+	defer broker.Close()
 
-	// init log service
-	logInit()
+	// cancelled on SIGINT/SIGTERM
+	ctx := shutdownContext()
 
-	// handle channels from web server
-	channelsWebServer()
+	// bring back the keys expired by quota after each daily reset
+	go keyPool.RunReinstatement(ctx)
 
-	if nonFlag || flagPlaylist {
-		startPlaylist()
-	}
+	// the channels blacklisted while the service runs are skipped from the next refresh on
+	go refreshBlacklist(ctx)
 
-	// buscar videos list de upcomings
-	if nonFlag || flagSearcher {
-		logrus.Info("[  *  ] Searching for videos on search.list by upcoming videos and channels ...")
-		channelsSearch()
-	}
+	// the jobs read the new settings on their next run
+	go watchSettings(ctx, entities.ConfigPath(configFlag))
 
-	// // trata videos recebidos
-	if nonFlag || flagVideo {
-		logrus.Info("[  *  ] Processing all the videos from the queue ...")
-		consumeVideo()
-	}
+	job(ctx)
+
+	logrus.Info("[  *  ] The service has been stopped")
 }
 
+// shutdownContext returns a context cancelled by SIGINT or SIGTERM. The work in progress is
+// given until the shutdown timeout to finish, then the process is terminated
+func shutdownContext() context.Context {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+
+		timeout := entities.GetShutdownTimeout()
+
+		logrus.WithFields(logrus.Fields{
+			"signal":  sig.String(),
+			"timeout": timeout.String(),
+		}).Warning("[  *  ] Shutting down, waiting for the work in progress ...")
+
+		cancel()
+
+		select {
+		case <-signals:
+			logrus.Warning("Second signal received, exiting now")
+		case <-time.After(timeout):
+			logrus.Error("The work in progress didn't finish before the shutdown timeout")
+		}
+
+		os.Exit(1)
+	}()
+
+	return ctx
+}
+
+// initService connects to the broker and loads everything the crawls and the consumer need
 func initService() {
 
-	flag.BoolVar(&flagPlaylist, "p", false, "runs only the playlist")
-	flag.BoolVar(&flagSearcher, "s", false, "runs only the searcher")
-	flag.BoolVar(&flagVideo, "v", false, "runs only the videos available in the consumer")
-	flag.Parse()
+	logrus.WithFields(logrus.Fields{
+		"env": entities.GetEnv(),
+	}).Info("[  *  ] The service has been started")
+
+	logrus.Info("Connecting to the broker ...")
+	broker = rabbit.New()
 
-	// if there is no flag , consider as running all the robot
-	if !flagPlaylist && !flagSearcher && !flagVideo {
-		fmt.Println("--> no flag defined. Run full application")
-		nonFlag = true
+	if err := broker.Connect(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err": err.Error(),
+		}).Fatal("Error to connect to the broker")
 	}
 
-	fmt.Println("")
-	fmt.Println("=============================================")
-	fmt.Println("        The service has been started         ")
-	fmt.Println("=============================================")
-	fmt.Println("")
+	logrus.Info("Loading storage, keys and blacklist ...")
+	initStorage()
+}
 
-	fmt.Println("loading rabbit settings...")
-	amqURL = entities.GetRabbitConnString()
+// initStorage loads the settings, the storage, the keys and the blacklist.
+// It is all the commands that don't use the broker need
+func initStorage() {
 
-	fmt.Println("loading environment...")
-	environment = entities.GetEnv()
+	initRepositories()
 
-	fmt.Println("loading web server settings...")
-	webserver = entities.GetWebServer().BaseURL
-	endpoints = entities.GetWebServerEndpoints()
+	keyPool = dao.NewKeyPool(repositories.Keys, entities.GetQuotaSettings().DailyLimit)
 
-	fmt.Println("loading authoziation keys...")
-	authorization := dao.NewAuthorizationService()
-	tokens := authorization.Index()
+	if err := keyPool.Load(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err": err.Error(),
+		}).Error("Error to load the keys")
+	}
 
-	for _, val := range tokens {
-		authKeys = append(authKeys, val.Token)
+	loadBlacklist()
+}
+
+// initRepositories opens the shared database pool, or keeps everything in memory for dry runs
+func initRepositories() {
+
+	dbSettings := entities.GetDatabaseSettings()
+
+	if dbSettings.Driver == entities.DatabaseDriverMemory {
+		logrus.Warning("Using in memory storage, nothing will be persisted")
+		repositories = memory.NewRepositories()
+
+		// there is no table to read the keys from, use the ones from the settings
+		for _, token := range entities.GetAuthKeys() {
+			repositories.Keys.Insert(dao.Authorization{Token: token})
+		}
+
+		return
+	}
+
+	var err error
+	database, err = dao.Open(dbSettings.DSN, dbSettings.MaxOpenConns, dbSettings.MaxIdleConns, dbSettings.Lifetime())
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err": err.Error(),
+		}).Fatal("Error to connect to the database")
 	}
 
+	repositories = dao.NewMySQLRepositories(database)
 }
 
-func channelsWebServer() {
-	logrus.Info("[  *  ] Searching for channels in the web server ...")
-	err := NewChannelWebListService().UpdateChannelsFromWebServer()
+func channelsWebServer(ctx context.Context) {
+	logFrom(ctx).Info("[  *  ] Searching for channels in the web server ...")
+	err := NewChannelWebListService().UpdateChannelsFromWebServer(ctx)
 	_errors.HandleError("Error to update channels from web server", err, false)
 }
 
-func channelsSearch() {
-	logrus.Info("[  *  ] Searching videos from channel list")
-	err := NewChannelWebListService().SearchVideosByChannels()
+func channelsSearch(ctx context.Context) {
+	logFrom(ctx).Info("[  *  ] Searching videos from channel list")
+	err := NewChannelWebListService().SearchVideosByChannels(ctx)
 	_errors.HandleError("Error to retrieve channel videos from youtube", err, false)
 }
 
-func consumeVideo() {
+// consumeVideo consumes the videos queue. Workers and prefetch override the settings when greater than zero
+func consumeVideo(ctx context.Context, workers int, prefetch int) {
 
-	conn, err := amqp.Dial(amqURL)
-	failOnError(err, "Failed to connect to RabbitMQ")
+	consumer := rabbit.NewConsumer("to.youtuber.videos").Workers(workers, prefetch)
 
-	defer conn.Close()
+	err := consumer.Run(ctx, func(d amqp.Delivery) error {
+		// the message in progress is not cancelled by the shutdown, it has until the timeout to finish
+		msgCtx, cancel := context.WithTimeout(context.Background(), entities.GetShutdownTimeout())
+		defer cancel()
 
-	ch, err := conn.Channel()
-	failOnError(err, "Failed to open a channel")
-	defer ch.Close()
+		// all the logs of the message have its correlation id, and the message published from it too
+		msgCtx = withLog(msgCtx, logrus.Fields{
+			"correlation_id": d.CorrelationId,
+		})
+		msgCtx = rabbit.WithCorrelationID(msgCtx, d.CorrelationId)
 
-	q, err := ch.QueueDeclare(
-		"to.youtuber.videos", // name
-		true,                 // durable
-		false,                // delete when unused
-		false,                // exclusive
-		false,                // no-wait
-		nil,                  // arguments
-	)
-	failOnError(err, "Failed to declare a queue")
+		return classifyVideoError(receivedVideoData(msgCtx, d))
+	})
 
-	err = ch.Qos(1, 0, false)
+	if err != nil {
+		logFrom(ctx).WithFields(logrus.Fields{
+			"err": err,
+		}).Fatal("The consumer has stopped")
+	}
+}
 
-	failOnError(err, "Failed to set QoS")
+// classifyVideoError tells the consumer what to do with a failed message:
+// without quota it waits in the parked queue until the keys are reinstated,
+// messages that can't be read are never tried again, the others go to the retry queues
+func classifyVideoError(err error) error {
 
-	msgs, err := ch.Consume(
-		q.Name, // queue
-		"",     // consumer
-		false,  // auto ack
-		false,  // exclusive
-		false,  // no local
-		false,  // no wait
-		nil,    // args
-	)
-	failOnError(err, "Failed to register a consumer")
+	if err == nil {
+		return nil
+	}
 
-	forever := make(chan bool)
+	ytErr := _errors.Classify(err)
 
-	go func() {
-		for d := range msgs {
-			err := receivedVideoData(d)
+	if err == dao.ErrNoKeyAvailable || ytErr.KeyExhausted() {
+		return rabbit.Park(err, dao.NextQuotaReset(time.Now()).Add(time.Minute))
+	}
 
-			d.Ack(false)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
 
-			if err != nil {
-				//d.Reject(true)
-				logrus.WithFields(logrus.Fields{
-					"err": err,
-				}).Warning("Message Rejected due to an error!!!")
-			}
-		}
-	}()
+	if err == errEmptyMessage || errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return rabbit.Permanent(err)
+	}
 
-	log.Printf(" [*] Waiting for logs. To exit press CTRL+C")
-	<-forever
+	return err
 }
 
-func receivedVideoData(d amqp.Delivery) error {
+// errEmptyMessage - the message received has no body
+var errEmptyMessage = errors.New("The string received is empty")
 
-	key, err, _ := dao.GetNewKey(authKeys, false)
-
-	if err != nil {
-		return err
-	}
-
-	logrus.Info("---------------------------------> Video received from queue <---------------------------------")
+func receivedVideoData(ctx context.Context, d amqp.Delivery) error {
 
 	message := ListOfIdsFromSearch{}
 
 	if string(d.Body) == "" {
-		return errors.New("The string received is empty")
+		return errEmptyMessage
 	}
 
-	err = json.Unmarshal(d.Body, &message)
+	err := json.Unmarshal(d.Body, &message)
 
 	if err != nil {
 		return err
 	}
 
+	ctx = withLog(ctx, logrus.Fields{
+		"source": message.Source,
+	})
+
+	log := logFrom(ctx)
+
+	log.Info("---------------------------------> Video received from queue <---------------------------------")
+
 	// valida se o video já foi coletado em algum momento no passado
-	// consulto o id no banco de dados, e se já estiver lá , não adiciono a lista de ids.
+	// consulto todos os ids no banco de dados de uma vez, e se já estiver lá , não adiciono a lista de ids.
 	// Assim a API irá buscar apenas os videos necessários
-	// pra isso defino o dao service
-	videoDao := dao.NewVideosService()
+	existing, err := repositories.Videos.Existing(message.IDs)
+
+	if err != nil {
+		return err
+	}
+
 	var videos []string
 
 	i := 0
@@ -200,14 +287,11 @@ func receivedVideoData(d amqp.Delivery) error {
 
 	// looping pelos ids recebidos pela mensage
 	for _, val := range message.IDs {
-		// consulto no banco de dados
-		v := videoDao.Show(val)
 		// se existir então pulo o video
-		if v.ID > 0 {
+		if existing[val] {
 
-			logrus.WithFields(logrus.Fields{
-				"id":       v.ID,
-				"video_id": v.VideoID,
+			log.WithFields(logrus.Fields{
+				"video_id": val,
 			}).Info("Video refused because it has already been sent!!")
 		} else {
 			// adiciono na lista de videos
@@ -218,11 +302,16 @@ func receivedVideoData(d amqp.Delivery) error {
 		t++
 	}
 
-	logrus.WithFields(logrus.Fields{
+	log.WithFields(logrus.Fields{
 		"total":     strconv.Itoa(t),
-		"proccesed": strconv.Itoa(i),
+		"processed": strconv.Itoa(i),
 	}).Info("[==] videos to be search after remove duplicates")
 
+	// todos os videos já foram enviados, não gasto quota com a api
+	if len(videos) <= 0 {
+		return nil
+	}
+
 	// convert into string a lista de videos. Será utilizada no campo de pesquisa do youtube
 	justString := strings.Join(videos, ",")
 	// justString := strings.Join(message.IDs, ",")
@@ -231,108 +320,108 @@ func receivedVideoData(d amqp.Delivery) error {
 	// ys := NewYotubeService(authKeys[0])
 	ys := NewYotubeService()
 
-	err = ys.SearchVideoByID(message.Source, justString, key)
-
-	if err != nil {
-		t, err := _errors.VerifyError403(err)
+	return callWithKey(ctx, dao.QuotaCostVideos, func(key string) error {
+		return ys.SearchVideoByID(ctx, message.Source, justString, key)
+	})
 
-		if t {
-			logrus.Panic("Error 403 - need to be threated")
-		}
-
-		return err
-	}
-
-	return nil
+}
 
+// startSearcher searches the categories from the settings
+func startSearcher(ctx context.Context) {
+	searchCategories(ctx, entities.GetCategories())
 }
 
-func startSearcher() {
+// searchCategories searches the videos of each category and publishes them
+func searchCategories(ctx context.Context, categoryList []string) {
 
-	// obtem as keys de acesso da base somente na primeira execução, nas demais utiliza a variavel armazenada
-	authKeys = entities.GetAuthKeys()
+	for _, val := range categoryList {
 
-	if len(authKeys) <= 0 {
-		logrus.WithFields(logrus.Fields{
-			"autheKeysCount": "0",
-		}).Error("There are no more auth keys available")
-	}
+		// stop before the next category when the service is shutting down
+		if ctx.Err() != nil {
+			return
+		}
 
-	categoryList := entities.GetCategories()
+		category := val
 
-	for _, val := range categoryList {
+		err := callWithKey(ctx, dao.QuotaCostSearch, func(key string) error {
+			return NewYotubeService().RunService(ctx, key, category)
+		})
 
-		ys := NewYotubeService()
-		err := ys.RunService(authKeys[0], val)
+		if err == dao.ErrNoKeyAvailable {
+			logFrom(ctx).WithFields(logrus.Fields{
+				"autheKeysCount": keyPool.Available(),
+			}).Error("There are no more auth keys available")
 
-		if err != nil {
+			break
+		}
 
-			if strings.Contains(err.Error(), "AP001") {
-				continue
-			} else {
-				// check if the error is 403
-				t, _ := _errors.VerifyError403(err)
-				// define if is or not
-				if t {
-					break
-				}
-			}
+		if err != nil && err != errNullPages {
+			_errors.HandleError("Error to retrieve data from category", err, false)
 		}
 	}
 }
 
-func logInit() {
+// maxCallAttempts is how many times a youtube call is tried before giving up
+const maxCallAttempts = 3
 
-	fmt.Println("setting log configuration for: " + environment + " environment")
+// callWithKey runs the call with the key with the most quota available and reacts
+// to the cause of the youtube error: keys without quota are exhausted until the reset,
+// broken keys are disabled, and in both cases the call is tried again with another key.
+// Temporary errors are tried again after a while. Any other error is returned to the caller
+func callWithKey(ctx context.Context, cost int64, call func(key string) error) error {
 
-	if environment == "development" {
+	var err error
 
-		Formatter := new(logrus.TextFormatter)
-		Formatter.TimestampFormat = "02-01-2006 15:04:05"
-		Formatter.FullTimestamp = true
-		logrus.SetFormatter(Formatter)
+	for attempt := 1; attempt <= maxCallAttempts; attempt++ {
 
-	} else {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-		f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-		Formatter := new(logrus.TextFormatter)
+		key, kerr := keyPool.Acquire(cost)
 
-		Formatter.TimestampFormat = "02-01-2006 15:04:05"
-		Formatter.FullTimestamp = true
-		logrus.SetFormatter(Formatter)
-		if err != nil {
-			// Cannot open log file. Logging to stderr
-			fmt.Println(err)
-		} else {
-			logrus.SetOutput(f)
+		if kerr != nil {
+			return kerr
 		}
 
-	}
-
-	logrus.SetLevel(logrus.DebugLevel)
+		err = call(key)
 
-	fmt.Println("")
-	fmt.Println("")
-	fmt.Println("")
-	fmt.Println("")
-	fmt.Println("")
-	fmt.Println("")
+		ytErr := _errors.Classify(err)
 
-	// ===================
-	// EXAMPLES
-	// ===================
-	// logrus.Trace("Something very low level.")
-	// logrus.Debug("Useful debugging information.")
-	// logrus.Info("Something noteworthy happened!")
-	// logrus.Warn("You should probably take a look at this.")
-	// logrus.Error("Something failed but I'm not quitting.")
+		if ytErr == nil {
+			return nil
+		}
 
-	// Calls os.Exit(1) after logging
-	// logrus.Fatal("Bye.")
+		switch {
+		case ytErr.KeyExhausted():
+			keyPool.Exhaust(key)
+		case ytErr.Kind == _errors.KindKeyInvalid:
+			keyPool.Disable(key, dao.ExpiredReasonInvalid)
+		case ytErr.Kind == _errors.KindAccessNotConfigured:
+			keyPool.Disable(key, dao.ExpiredReasonAccessNotConfigured)
+		case ytErr.Retryable:
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		case ytErr.Kind == _errors.KindInvalidPageToken:
+			// the checkpoint has been cleared, try again from the first page
+		default:
+			// the key is fine, the problem is the request itself (private playlist, not found...)
+			if ytErr.Status > 0 {
+				logFrom(ctx).WithFields(logrus.Fields{
+					"kind":            ytErr.Kind.String(),
+					"reason":          ytErr.Reason,
+					"status":          ytErr.Status,
+					"key_fingerprint": dao.Fingerprint(key),
+				}).Warning("Youtube api refused the request")
+			}
 
-	// Calls panic() after logging
-	// logrus.Panic("I'm bailing.")
+			return err
+		}
+	}
 
+	return err
 }
 
 func failOnError(err error, msg string) {
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"soliveboa/youtuber/v2/dao"
+	"soliveboa/youtuber/v2/dao/memory"
+	"soliveboa/youtuber/v2/rabbit"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+// useMemoryStorage replaces the storage and the keys of the service by in memory ones
+func useMemoryStorage(t *testing.T, tokens ...string) {
+
+	t.Helper()
+
+	repositories = memory.NewRepositories()
+
+	for _, token := range tokens {
+		repositories.Keys.Insert(dao.Authorization{Token: token})
+	}
+
+	keyPool = dao.NewKeyPool(repositories.Keys, dao.DefaultDailyQuota)
+
+	if len(tokens) > 0 {
+		if err := keyPool.Load(); err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+	}
+}
+
+func apiError(code int, reason string) error {
+	return &googleapi.Error{Code: code, Message: reason, Errors: []googleapi.ErrorItem{{Reason: reason}}}
+}
+
+func TestCallWithKey(t *testing.T) {
+
+	notFound := apiError(http.StatusNotFound, "playlistNotFound")
+
+	tests := []struct {
+		name        string
+		responses   []error
+		wantErr     error
+		wantCalls   int
+		wantReasons map[string]string
+	}{
+		{"success", []error{nil}, nil, 1, map[string]string{}},
+		{"quota exceeded, next key", []error{apiError(http.StatusForbidden, "quotaExceeded"), nil}, nil, 2,
+			map[string]string{"key-aaaaaa": dao.ExpiredReasonQuota}},
+		{"invalid key, next key", []error{apiError(http.StatusBadRequest, "keyInvalid"), nil}, nil, 2,
+			map[string]string{"key-aaaaaa": dao.ExpiredReasonInvalid}},
+		{"api not enabled, next key", []error{apiError(http.StatusForbidden, "accessNotConfigured"), nil}, nil, 2,
+			map[string]string{"key-aaaaaa": dao.ExpiredReasonAccessNotConfigured}},
+		{"invalid page token, again", []error{apiError(http.StatusBadRequest, "invalidPageToken"), nil}, nil, 2, map[string]string{}},
+		{"request refused, no retry", []error{notFound}, notFound, 1, map[string]string{}},
+		{"all the keys without quota", []error{
+			apiError(http.StatusForbidden, "quotaExceeded"),
+			apiError(http.StatusForbidden, "quotaExceeded"),
+		}, dao.ErrNoKeyAvailable, 2, map[string]string{"key-aaaaaa": dao.ExpiredReasonQuota, "key-bbbbbb": dao.ExpiredReasonQuota}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			useMemoryStorage(t, "key-aaaaaa", "key-bbbbbb")
+
+			// the first key is the one with the most quota
+			keyPool.Charge("key-bbbbbb", 1)
+
+			var keys []string
+
+			err := callWithKey(context.Background(), dao.QuotaCostSearch, func(key string) error {
+				keys = append(keys, key)
+				return tt.responses[len(keys)-1]
+			})
+
+			if err != tt.wantErr {
+				t.Errorf("callWithKey() = %v, want %v", err, tt.wantErr)
+			}
+
+			if len(keys) != tt.wantCalls {
+				t.Errorf("calls = %v, want %d", keys, tt.wantCalls)
+			}
+
+			for _, auth := range repositories.Keys.Index() {
+				if auth.ExpiredReason != tt.wantReasons[auth.Token] {
+					t.Errorf("%s expired reason = %q, want %q", auth.Token, auth.ExpiredReason, tt.wantReasons[auth.Token])
+				}
+			}
+		})
+	}
+}
+
+func TestCallWithKeyCancelled(t *testing.T) {
+
+	useMemoryStorage(t, "key-aaaaaa")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := callWithKey(ctx, dao.QuotaCostSearch, func(key string) error {
+		t.Error("no call must be made after the shutdown")
+		return nil
+	})
+
+	if err != context.Canceled {
+		t.Errorf("callWithKey() = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestClassifyVideoError(t *testing.T) {
+
+	var permanent *rabbit.PermanentError
+	var parked *rabbit.ParkError
+
+	tests := []struct {
+		name          string
+		err           error
+		wantPermanent bool
+		wantParked    bool
+	}{
+		{"no key available", dao.ErrNoKeyAvailable, false, true},
+		{"quota exceeded", apiError(http.StatusForbidden, "quotaExceeded"), false, true},
+		{"empty message", errEmptyMessage, true, false},
+		{"invalid json", json.Unmarshal([]byte("{"), &ListOfIdsFromSearch{}), true, false},
+		{"wrong json", json.Unmarshal([]byte(`{"ids": 1}`), &ListOfIdsFromSearch{}), true, false},
+		{"temporary", apiError(http.StatusServiceUnavailable, "backendError"), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got := classifyVideoError(tt.err)
+
+			if errors.As(got, &permanent) != tt.wantPermanent || errors.As(got, &parked) != tt.wantParked {
+				t.Errorf("classifyVideoError(%v) = %#v, want permanent %v parked %v", tt.err, got, tt.wantPermanent, tt.wantParked)
+			}
+
+			if !errors.Is(got, tt.err) {
+				t.Error("the original error must be kept")
+			}
+		})
+	}
+
+	if classifyVideoError(nil) != nil {
+		t.Error("classifyVideoError(nil) must be nil")
+	}
+}
@@ -0,0 +1,4 @@
+-- quota units used by each key on the current quota day (pacific time)
+ALTER TABLE authorization_keys
+    ADD COLUMN quota_used INT NOT NULL DEFAULT 0,
+    ADD COLUMN quota_date DATE NULL;
@@ -0,0 +1,7 @@
+-- keys expired by quota are reinstated after the daily reset, the others stay disabled
+ALTER TABLE authorization_keys
+    MODIFY COLUMN expired_at DATETIME NULL,
+    ADD COLUMN expired_reason VARCHAR(32) NULL AFTER expired_at;
+
+UPDATE authorization_keys SET expired_at = NULL WHERE expired_at = '0000-00-00 00:00:00';
+UPDATE authorization_keys SET expired_reason = 'quota' WHERE expired_at IS NOT NULL;
@@ -0,0 +1,7 @@
+-- one page token checkpoint per paged crawl (channel:<id>, category:<id>, playlist:<id>)
+DELETE FROM token_recovery;
+
+ALTER TABLE token_recovery
+    ADD COLUMN crawl_key VARCHAR(191) NOT NULL AFTER id,
+    ADD COLUMN updated_at DATETIME NULL,
+    ADD UNIQUE KEY token_recovery_crawl_key (crawl_key);
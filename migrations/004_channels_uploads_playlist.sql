@@ -0,0 +1,4 @@
+-- the uploads playlist of the channel, read from channels.list contentDetails
+ALTER TABLE channels
+    ADD COLUMN uploads_playlist_id VARCHAR(64) NULL,
+    ADD UNIQUE KEY channels_channel_id (channel_id);
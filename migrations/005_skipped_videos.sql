@@ -0,0 +1,11 @@
+-- videos dropped before being sent to the processor (ex: blacklisted channel)
+CREATE TABLE IF NOT EXISTS skipped_videos (
+    id INT NOT NULL AUTO_INCREMENT,
+    video_id VARCHAR(32) NOT NULL,
+    channel_id VARCHAR(64) NOT NULL DEFAULT '',
+    reason VARCHAR(32) NOT NULL,
+    source VARCHAR(64) NOT NULL DEFAULT '',
+    skipped_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY skipped_videos_video_id (video_id)
+);
@@ -0,0 +1,3 @@
+-- the consumer looks up whole messages of video IDs at once
+ALTER TABLE videos
+    ADD INDEX videos_video_id (video_id);
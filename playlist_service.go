@@ -1,39 +1,41 @@
 package main
 
 import (
+	"context"
 	"soliveboa/youtuber/v2/dao"
 	"soliveboa/youtuber/v2/entities"
 	_errors "soliveboa/youtuber/v2/errors"
-
-	"github.com/sirupsen/logrus"
 )
 
-func startPlaylist() {
+// startPlaylist crawls the playlists from the settings
+func startPlaylist(ctx context.Context) {
+	collectPlaylists(ctx, entities.GetPlaylists())
+}
 
-	logrus.Info("[  *  ] Searching for videos on playlist.list {upcoming videos} by ...")
+// collectPlaylists crawls the playlists and publishes their videos
+func collectPlaylists(ctx context.Context, p []string) {
 
-	// key a key to use
-	key, err, _ := dao.GetNewKey(authKeys, false)
+	logFrom(ctx).Info("[  *  ] Searching for videos on playlist.list {upcoming videos} by ...")
 
-	if err != nil {
-		failOnError(err, "error to get key from list")
+	for _, val := range p {
 
-	}
+		// stop before the next playlist when the service is shutting down
+		if ctx.Err() != nil {
+			return
+		}
 
-	p := entities.GetPlaylists()
+		playlistID := val
 
-	for _, val := range p {
-		ys := NewYotubeService()
-		err := ys.RunByPlaylist(key, val)
-		_errors.HandleError("Error to retrieve data from playlist", err, false)
+		err := callWithKey(ctx, dao.QuotaCostPlaylistItems, func(key string) error {
+			return NewYotubeService().RunByPlaylist(ctx, key, playlistID)
+		})
 
-		is403, _ := _errors.VerifyError403(err)
-		if is403 {
-			// remove the key from the array
-			_, _, keys := dao.GetNewKey(authKeys, true)
-			authKeys = keys
+		if err == dao.ErrNoKeyAvailable {
+			failOnError(err, "error to get key from list")
 			break
 		}
+
+		_errors.HandleError("Error to retrieve data from playlist", err, false)
 	}
 
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"soliveboa/youtuber/v2/dao"
+	"soliveboa/youtuber/v2/entities"
+	_errors "soliveboa/youtuber/v2/errors"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+// runQueries runs the keyword searches, one after the other
+func runQueries(ctx context.Context, queries []entities.Query) {
+
+	for _, val := range queries {
+
+		// stop before the next query when the service is shutting down
+		if ctx.Err() != nil {
+			return
+		}
+
+		query := val
+
+		err := callWithKey(ctx, dao.QuotaCostSearch, func(key string) error {
+			return NewYotubeService().RunByQuery(ctx, key, query)
+		})
+
+		if err == dao.ErrNoKeyAvailable {
+			failOnError(err, "error to get key from list")
+			break
+		}
+
+		if err != nil && err != errNullPages {
+			_errors.HandleError("Error to retrieve data from query", err, false)
+		}
+	}
+}
+
+// RunByQuery - retrieve the videos found by the keyword query. The messages have the source "query:<name>"
+// so the processor knows which query has found each video. Each query has its own checkpoint
+func (y Youtube) RunByQuery(ctx context.Context, k string, query entities.Query) error {
+
+	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(k))
+
+	if err != nil {
+		return err
+	}
+
+	pl := entities.GetParametersList()
+
+	call := youtubeService.Search.List(pl.Part)
+	call.Q(query.Query)
+	call.Type(pl.VideoType)
+	call.RegionCode(firstNonEmpty(query.RegionCode, pl.RegionCode))
+	call.RelevanceLanguage(firstNonEmpty(query.Language, pl.Language))
+	call.EventType(firstNonEmpty(query.EventType, pl.EventType))
+	call.MaxResults(pl.MaxResults)
+	call.PublishedAfter(pl.SearchPublishedAfter())
+	call.Order(pl.Order)
+
+	return runSearch(ctx, k, call, "query:"+query.Name, "")
+}
+
+// firstNonEmpty returns the value, or the default when it is empty
+func firstNonEmpty(value string, def string) string {
+
+	if value != "" {
+		return value
+	}
+
+	return def
+}
@@ -0,0 +1,415 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"soliveboa/youtuber/v2/entities"
+
+	guuid "github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// Defaults used when the settings don't define them
+const (
+	defaultMaxReconnects = 10
+	defaultMaxAttempts   = 5
+	defaultWorkers       = 1
+	defaultPrefetch      = 1
+)
+
+// Handler processes one delivery. The delivery is acked when it returns nil, otherwise it is sent
+// to a retry queue, to the parked queue (ParkError) or to the dead queue (PermanentError, or
+// when all the attempts have failed). It is called by all the workers at the same time
+type Handler func(d amqp.Delivery) error
+
+// Consumer - consumes a queue and restores the connection, topology and QoS
+// every time the broker closes the connection or the channel
+type Consumer struct {
+	url           string
+	queue         string
+	maxReconnects int
+	maxAttempts   int
+	retryDelays   []time.Duration
+	workers       int
+	prefetch      int
+}
+
+// NewConsumer creates the consumer of the queue bound to the exchange by its own name
+func NewConsumer(queue string) *Consumer {
+
+	settings := entities.GetConsumerSettings()
+
+	max := settings.MaxReconnects
+
+	if max <= 0 {
+		max = defaultMaxReconnects
+	}
+
+	attempts := settings.MaxAttempts
+
+	if attempts <= 0 {
+		attempts = defaultMaxAttempts
+	}
+
+	workers := settings.Workers
+
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+
+	prefetch := settings.Prefetch
+
+	if prefetch <= 0 {
+		prefetch = defaultPrefetch
+	}
+
+	return &Consumer{
+		url:           preapreURL(),
+		queue:         queue,
+		maxReconnects: max,
+		maxAttempts:   attempts,
+		retryDelays:   settings.Delays(),
+		workers:       workers,
+		prefetch:      prefetch,
+	}
+}
+
+// Workers overrides the workers and the prefetch from the settings. Values not greater than zero are ignored
+func (c *Consumer) Workers(workers int, prefetch int) *Consumer {
+
+	if workers > 0 {
+		c.workers = workers
+	}
+
+	if prefetch > 0 {
+		c.prefetch = prefetch
+	}
+
+	return c
+}
+
+// Run consumes the queue until the context is cancelled or the reconnection budget runs out.
+// The budget is restarted every time a connection succeeds. Returns nil when the context is cancelled
+func (c *Consumer) Run(ctx context.Context, handler Handler) error {
+
+	failures := 0
+	backoff := time.Second
+
+	for {
+		consumed, err := c.session(ctx, handler)
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if consumed {
+			failures = 0
+			backoff = time.Second
+		}
+
+		failures++
+
+		if failures > c.maxReconnects {
+			return fmt.Errorf("consumer gave up after %d reconnections: %v", c.maxReconnects, err)
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"err":     err,
+			"queue":   c.queue,
+			"attempt": failures,
+			"backoff": backoff.String(),
+		}).Warning("Consumer disconnected, reconnecting...")
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// session connects, declares the topology and starts the workers, each one on its own channel.
+// It runs until the connection or one of the channels is closed, or the context is cancelled.
+// It returns true only when the consumers of all the workers were registered
+func (c *Consumer) session(ctx context.Context, handler Handler) (bool, error) {
+
+	conn, err := amqp.Dial(c.url)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer conn.Close()
+
+	err = c.declare(conn)
+
+	if err != nil {
+		return false, err
+	}
+
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, c.workers)
+	ready := make(chan struct{}, c.workers)
+	var wg sync.WaitGroup
+
+	for i := 1; i <= c.workers; i++ {
+		wg.Add(1)
+
+		go func(id int) {
+			defer wg.Done()
+			errs <- c.worker(workerCtx, conn, id, ready, handler)
+		}(i)
+	}
+
+	// a worker that can't register its consumer fails the session before it counts as connected
+	for registered := 0; registered < c.workers; {
+		select {
+		case <-ready:
+			registered++
+			continue
+
+		case <-ctx.Done():
+			err = ctx.Err()
+
+		case reason := <-connClosed:
+			err = closeError("connection", reason)
+
+		case err = <-errs:
+		}
+
+		cancel()
+		wg.Wait()
+
+		return false, err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"queue":    c.queue,
+		"workers":  c.workers,
+		"prefetch": c.prefetch,
+	}).Info(" [*] Waiting for messages")
+
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+
+	case reason := <-connClosed:
+		err = closeError("connection", reason)
+
+	case err = <-errs:
+	}
+
+	// the other workers finish the message in progress before the connection is closed
+	cancel()
+	wg.Wait()
+
+	return true, err
+}
+
+// declare creates the queue and the retry topology
+func (c *Consumer) declare(conn *amqp.Connection) error {
+
+	ch, err := conn.Channel()
+
+	if err != nil {
+		return err
+	}
+
+	defer ch.Close()
+
+	err = DeclareQueue(ch, c.queue)
+
+	if err != nil {
+		return err
+	}
+
+	return declareRetryTopology(ch, c.queue, c.retryDelays)
+}
+
+// worker consumes the queue on its own channel, one message at a time, until the channel
+// is closed or the context is cancelled. It signals ready once its consumer is registered
+func (c *Consumer) worker(ctx context.Context, conn *amqp.Connection, id int, ready chan<- struct{}, handler Handler) error {
+
+	ch, err := conn.Channel()
+
+	if err != nil {
+		return err
+	}
+
+	defer ch.Close()
+
+	// the failed messages are moved with confirmation before being acked
+	retryCh, err := conn.Channel()
+
+	if err != nil {
+		return err
+	}
+
+	pc, err := newPublishChannel(conn, retryCh)
+
+	if err != nil {
+		return err
+	}
+
+	defer retryCh.Close()
+
+	err = ch.Qos(c.prefetch, 0, false)
+
+	if err != nil {
+		return err
+	}
+
+	tag := fmt.Sprintf("youtuber-%d-%d", time.Now().UnixNano(), id)
+
+	msgs, err := ch.Consume(
+		c.queue, // queue
+		tag,     // consumer
+		false,   // auto ack
+		false,   // exclusive
+		false,   // no local
+		false,   // no wait
+		nil,     // args
+	)
+
+	if err != nil {
+		return err
+	}
+
+	ready <- struct{}{}
+
+	chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.stop(ch, tag, msgs)
+			return ctx.Err()
+
+		case d, ok := <-msgs:
+
+			if !ok {
+				return errors.New("deliveries channel closed")
+			}
+
+			c.handle(pc, id, d, handler)
+
+		case reason := <-chClosed:
+			return closeError("channel", reason)
+		}
+	}
+}
+
+// stop cancels the consumer so the broker stops delivering, and sends back to the queue
+// the deliveries already received but not handled yet
+func (c *Consumer) stop(ch *amqp.Channel, tag string, msgs <-chan amqp.Delivery) {
+
+	err := ch.Cancel(tag, false)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":   err.Error(),
+			"queue": c.queue,
+		}).Error("Error to cancel the consumer")
+
+		return
+	}
+
+	// the deliveries channel is closed by the cancel once the buffered ones are read
+	for d := range msgs {
+		d.Nack(false, true)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"queue": c.queue,
+	}).Info(" [*] Consumer stopped")
+}
+
+// handle runs the handler and acks the delivery. Failed deliveries are moved to the
+// retry, parked or dead queue; if they can't be moved they go back to the queue.
+// The delivery is always acked on the channel of the worker that received it
+func (c *Consumer) handle(pc *publishChannel, worker int, d amqp.Delivery, handler Handler) {
+
+	// the logs of the message are told apart by the correlation id, the old messages may not have one
+	if d.CorrelationId == "" {
+		d.CorrelationId = guuid.New().String()
+	}
+
+	failure := handler(d)
+
+	if failure == nil {
+		d.Ack(false)
+		return
+	}
+
+	target, err := c.republish(pc, d, failure)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":            err.Error(),
+			"failure":        failure.Error(),
+			"queue":          c.queue,
+			"worker":         worker,
+			"correlation_id": d.CorrelationId,
+		}).Error("Error to move the failed message, sending it back to the queue")
+
+		d.Nack(false, true)
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"err":            failure.Error(),
+		"attempt":        RetryCount(d) + 1,
+		"target":         target,
+		"worker":         worker,
+		"correlation_id": d.CorrelationId,
+	}).Warning("Message moved due to an error")
+
+	d.Ack(false)
+}
+
+// DeclareQueue declares the exchange and the durable queue, bound by the queue name
+func DeclareQueue(ch *amqp.Channel, queue string) error {
+
+	err := declareExchange(ch)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(
+		queue, // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return ch.QueueBind(queue, queue, ExchangeName, false, nil)
+}
+
+func closeError(what string, reason *amqp.Error) error {
+
+	if reason == nil {
+		return errors.New(what + " closed")
+	}
+
+	return errors.New(what + " closed: " + reason.Error())
+}
@@ -1,106 +1,383 @@
 package rabbit
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
 	"soliveboa/youtuber/v2/entities"
 
 	guuid "github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 
 	"github.com/streadway/amqp"
 )
 
+// ExchangeName - exchange used by all the messages of the service
+const ExchangeName = "youtuber"
+
+// defaultChannelPool - channels kept open when the settings don't define it
+const defaultChannelPool = 4
+
+// confirmTimeout - how long to wait for the broker to confirm a message
+const confirmTimeout = 10 * time.Second
+
+// Publishing errors
+var (
+	// ErrNotConnected is returned when the broker connection is down and being restored
+	ErrNotConnected = errors.New("not connected to the broker")
+	// ErrUnroutable is returned when no queue is bound to the routing key
+	ErrUnroutable = errors.New("message returned by the broker: unroutable")
+	// ErrNacked is returned when the broker refuses the message
+	ErrNacked = errors.New("message not acknowledged by the broker")
+	// ErrConfirmTimeout is returned when the broker doesn't confirm the message in time
+	ErrConfirmTimeout = errors.New("timeout waiting for the broker confirmation")
+)
+
+// publishChannel - channel in confirm mode, with the returns of the mandatory messages
+type publishChannel struct {
+	ch       *amqp.Channel
+	conn     *amqp.Connection
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+func newPublishChannel(conn *amqp.Connection, ch *amqp.Channel) (*publishChannel, error) {
+
+	err := ch.Confirm(false)
+
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	return &publishChannel{
+		ch:       ch,
+		conn:     conn,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, 1)),
+	}, nil
+}
+
 // ServiceCall - Contains the service structure
+// One ServiceCall holds a single long-lived connection, shared by every publisher of the process,
+// with a pool of channels. The connection is restored automatically when the broker closes it
 type ServiceCall struct {
-	Publisher *PublisherCall
-}
+	url      string
+	poolSize int
 
-// PublisherCall - Contains the publisher structure
-type PublisherCall struct {
-	conn         *amqp.Connection
-	channel      *amqp.Channel
-	url          string
-	exchangeName string
-	routeKey     string
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	channels chan *publishChannel
+	closed   bool
 }
 
 // New - Generaters a new service methodos
 func New() *ServiceCall {
 
-	return &ServiceCall{}
+	size := entities.GetRabbitSettings().ChannelPool
+
+	if size <= 0 {
+		size = defaultChannelPool
+	}
+
+	return &ServiceCall{
+		url:      preapreURL(),
+		poolSize: size,
+	}
+}
+
+// Connect - Create the connection and keeps it alive until Close is called
+func (p *ServiceCall) Connect() error {
+
+	notify, err := p.dial()
+
+	if err != nil {
+		return err
+	}
+
+	go p.watch(notify)
+
+	return nil
 }
 
-// Connect - Create the connection
-// You can pass different parameters to connect
-// (hostname, port, username, password)
-func (p *ServiceCall) Connect() (*PublisherCall, error) {
+// Close the connection. It will not be restored anymore
+func (p *ServiceCall) Close() error {
 
-	u := preapreURL()
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	// connect
-	conn, err := amqp.Dial(u)
+	p.closed = true
+
+	if p.conn == nil {
+		return nil
+	}
+
+	return p.conn.Close()
+}
+
+func (p *ServiceCall) dial() (chan *amqp.Error, error) {
+
+	conn, err := amqp.Dial(p.url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
 
-	// validating error
 	if err != nil {
-		return &PublisherCall{}, err
+		conn.Close()
+		return nil, err
 	}
 
-	// create channel
-	c, err := conn.Channel()
+	err = declareExchange(ch)
 
-	// validating error
 	if err != nil {
-		return &PublisherCall{}, err
+		conn.Close()
+		return nil, err
 	}
 
-	r := &PublisherCall{
-		url:     u,
-		conn:    conn,
-		channel: c,
+	pc, err := newPublishChannel(conn, ch)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
 
-	return r, nil
+	p.mu.Lock()
+	p.conn = conn
+	p.channels = make(chan *publishChannel, p.poolSize)
+	p.channels <- pc
+	p.mu.Unlock()
+
+	return conn.NotifyClose(make(chan *amqp.Error, 1)), nil
 }
 
-// Exchange declare the exchange that will be used
-func (p *PublisherCall) Exchange(routeKey string) (*PublisherCall, error) {
+// watch restores the connection, with backoff, every time the broker closes it
+func (p *ServiceCall) watch(notify chan *amqp.Error) {
 
-	err := p.channel.ExchangeDeclare(
-		"youtuber", // name
-		"direct",   // type
-		true,       // durable
-		false,      // auto-deleted
-		false,      // internal
-		false,      // no-wait
-		nil,        // arguments
-	)
+	for {
+		reason, ok := <-notify
+
+		if p.isClosed() {
+			return
+		}
+
+		p.mu.Lock()
+		p.conn = nil
+		p.mu.Unlock()
+
+		if ok {
+			logrus.WithFields(logrus.Fields{
+				"err": reason.Error(),
+			}).Warning("Connection to the broker closed, reconnecting...")
+		}
+
+		backoff := time.Second
+
+		for {
+			time.Sleep(backoff)
+
+			if p.isClosed() {
+				return
+			}
+
+			var err error
+			notify, err = p.dial()
+
+			if err == nil {
+				logrus.Info("Connection to the broker restored")
+				break
+			}
+
+			logrus.WithFields(logrus.Fields{
+				"err":     err.Error(),
+				"backoff": backoff.String(),
+			}).Error("Error to reconnect to the broker")
+
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		}
+	}
+}
+
+func (p *ServiceCall) isClosed() bool {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.closed
+}
+
+// channel takes a channel from the pool, or opens a new one when the pool is empty
+func (p *ServiceCall) channel() (*publishChannel, error) {
+
+	p.mu.Lock()
+	conn, channels := p.conn, p.channels
+	p.mu.Unlock()
+
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	select {
+	case pc := <-channels:
+		return pc, nil
+	default:
+	}
+
+	ch, err := conn.Channel()
 
 	if err != nil {
-		return p, err
+		return nil, err
+	}
+
+	return newPublishChannel(conn, ch)
+}
+
+// release gives the channel back to the pool. Channels of an old connection are dropped
+func (p *ServiceCall) release(pc *publishChannel) {
+
+	p.mu.Lock()
+	current, channels := p.conn, p.channels
+	p.mu.Unlock()
+
+	if pc.conn != current {
+		return
+	}
+
+	select {
+	case channels <- pc:
+	default:
+		pc.ch.Close()
 	}
+}
+
+// correlationKey - key of the correlation id in the context
+type correlationKey struct{}
+
+// WithCorrelationID returns a context whose published messages have the correlation id,
+// so the messages of a job run or of a consumed message can be followed across the queues
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the correlation id of the context, a new one when there is none
+func CorrelationID(ctx context.Context) string {
 
-	p.exchangeName = "youtuber"
-	p.routeKey = routeKey
+	if id, ok := ctx.Value(correlationKey{}).(string); ok && id != "" {
+		return id
+	}
 
-	return p, nil
+	return guuid.New().String()
 }
 
-// Publish message to the Exchange
-func (p *PublisherCall) Publish(body []byte) (*PublisherCall, error) {
+// Publish message to the Exchange with the given routing key and the correlation id of the context.
+// It returns only after the broker has confirmed the message. Messages that can't be routed
+// to any queue are returned by the broker and reported as ErrUnroutable
+func (p *ServiceCall) Publish(ctx context.Context, routeKey string, body []byte) error {
 
-	err := p.channel.Publish(
-		"youtuber", // exchange
-		p.routeKey, // routing key
-		false,      // mandatory
-		false,      // immediate
-		amqp.Publishing{
-			DeliveryMode:  amqp.Persistent,
-			CorrelationId: guuid.New().String(),
-			AppId:         "service.youtuber",
-			ContentType:   "application/json",
-			Body:          body,
-		})
+	pc, err := p.channel()
 
-	return p, err
+	if err != nil {
+		return err
+	}
+
+	err = pc.publish(routeKey, amqp.Publishing{
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: CorrelationID(ctx),
+		AppId:         "service.youtuber",
+		ContentType:   "application/json",
+		Body:          body,
+	})
 
+	if err != nil && !errors.Is(err, ErrUnroutable) && err != ErrNacked {
+		// the channel state is unknown after an error, don't give it back
+		pc.ch.Close()
+		return err
+	}
+
+	p.release(pc)
+
+	return err
+}
+
+// publish the mandatory message and waits for the broker confirmation
+func (pc *publishChannel) publish(routeKey string, msg amqp.Publishing) error {
+
+	err := pc.ch.Publish(
+		ExchangeName, // exchange
+		routeKey,     // routing key
+		true,         // mandatory
+		false,        // immediate
+		msg,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return pc.wait(routeKey)
+}
+
+// wait for the broker confirmation. A return, when there is one, always comes before the confirmation
+func (pc *publishChannel) wait(routeKey string) error {
+
+	timeout := time.NewTimer(confirmTimeout)
+	defer timeout.Stop()
+
+	var returned *amqp.Return
+
+	for {
+		select {
+		case ret := <-pc.returns:
+			returned = &ret
+
+		case conf, ok := <-pc.confirms:
+
+			if !ok {
+				return ErrNotConnected
+			}
+
+			// the return may still be buffered
+			select {
+			case ret := <-pc.returns:
+				returned = &ret
+			default:
+			}
+
+			if returned != nil {
+				return fmt.Errorf("%w (%s, routing key %s)", ErrUnroutable, returned.ReplyText, routeKey)
+			}
+
+			if !conf.Ack {
+				return ErrNacked
+			}
+
+			return nil
+
+		case <-timeout.C:
+			return ErrConfirmTimeout
+		}
+	}
+}
+
+// declareExchange declare the exchange that will be used
+func declareExchange(ch *amqp.Channel) error {
+
+	return ch.ExchangeDeclare(
+		ExchangeName, // name
+		"direct",     // type
+		true,         // durable
+		false,        // auto-deleted
+		false,        // internal
+		false,        // no-wait
+		nil,          // arguments
+	)
 }
 
 func preapreURL() string {
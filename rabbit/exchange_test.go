@@ -0,0 +1,22 @@
+package rabbit
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCorrelationID(t *testing.T) {
+
+	ctx := WithCorrelationID(context.Background(), "job-run-1")
+
+	if got := CorrelationID(ctx); got != "job-run-1" {
+		t.Errorf("CorrelationID = %q, want the one of the context", got)
+	}
+
+	// without one, every message gets its own
+	first, second := CorrelationID(context.Background()), CorrelationID(context.Background())
+
+	if first == "" || first == second {
+		t.Errorf("CorrelationID without one in the context = %q and %q, want two new ids", first, second)
+	}
+}
@@ -0,0 +1,203 @@
+package rabbit
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Headers set on the messages sent to the retry, dead and parked queues
+const (
+	RetryCountHeader = "x-retry-count"
+	LastErrorHeader  = "x-last-error"
+)
+
+// Suffixes of the queues created for each consumed queue
+const (
+	retrySuffix  = ".retry."
+	deadSuffix   = ".dead"
+	parkedSuffix = ".parked"
+)
+
+// ParkError - the message can't be processed until the given time. It goes to the parked queue
+// and comes back to the queue by itself when it expires
+type ParkError struct {
+	Err   error
+	Until time.Time
+}
+
+func (e *ParkError) Error() string {
+	return "parked until " + e.Until.Format(time.RFC3339) + ": " + e.Err.Error()
+}
+
+// Unwrap returns the original error
+func (e *ParkError) Unwrap() error {
+	return e.Err
+}
+
+// Park wraps the error of a message that must wait until the given time
+func Park(err error, until time.Time) error {
+	return &ParkError{Err: err, Until: until}
+}
+
+// PermanentError - the message will never be processed, it goes straight to the dead queue
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the original error
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps the error of a message that must not be tried again
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// RetryCount returns how many times the message has already been tried again
+func RetryCount(d amqp.Delivery) int {
+
+	switch v := d.Headers[RetryCountHeader].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+
+	return 0
+}
+
+// retryQueue returns the name of the retry queue of the delay. The delay is a queue argument
+// that can't be changed once declared, a new delay gets a new queue
+// (the old one sends its messages back to the queue and stays empty)
+func retryQueue(queue string, delay time.Duration) string {
+	return queue + retrySuffix + delay.String()
+}
+
+// declareRetryTopology declares, for the queue:
+// - one retry queue per delay, that sends the message back to the queue when the delay expires
+// - the parked queue, that sends the message back to the queue when the message expires
+// - the dead queue, that keeps the messages that failed after all the attempts
+func declareRetryTopology(ch *amqp.Channel, queue string, delays []time.Duration) error {
+
+	backToQueue := amqp.Table{
+		"x-dead-letter-exchange":    ExchangeName,
+		"x-dead-letter-routing-key": queue,
+	}
+
+	for _, delay := range delays {
+
+		args := amqp.Table{"x-message-ttl": int64(delay / time.Millisecond)}
+		for k, v := range backToQueue {
+			args[k] = v
+		}
+
+		err := declareBoundQueue(ch, retryQueue(queue, delay), args)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err := declareBoundQueue(ch, queue+parkedSuffix, backToQueue)
+
+	if err != nil {
+		return err
+	}
+
+	return declareBoundQueue(ch, queue+deadSuffix, nil)
+}
+
+func declareBoundQueue(ch *amqp.Channel, queue string, args amqp.Table) error {
+
+	_, err := ch.QueueDeclare(
+		queue, // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		args,  // arguments
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return ch.QueueBind(queue, queue, ExchangeName, false, nil)
+}
+
+// republish sends the failed delivery to the retry, parked or dead queue
+func (c *Consumer) republish(pc *publishChannel, d amqp.Delivery, failure error) (string, error) {
+
+	target, msg := c.route(d, failure)
+
+	return target, pc.publish(target, msg)
+}
+
+// route returns the queue where the failed delivery goes, with the message to publish there
+func (c *Consumer) route(d amqp.Delivery, failure error) (string, amqp.Publishing) {
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+
+	headers[LastErrorHeader] = failure.Error()
+
+	msg := amqp.Publishing{
+		Headers:       headers,
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: d.CorrelationId,
+		AppId:         d.AppId,
+		ContentType:   d.ContentType,
+		Body:          d.Body,
+	}
+
+	var parked *ParkError
+	var permanent *PermanentError
+
+	attempt := RetryCount(d) + 1
+	target := ""
+
+	switch {
+	case errors.As(failure, &parked):
+		// the attempt is not counted, the message has not really failed
+		target = c.queue + parkedSuffix
+
+		wait := time.Until(parked.Until)
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		msg.Expiration = strconv.FormatInt(int64(wait/time.Millisecond), 10)
+
+	// the message has been handled maxAttempts times, counting the first one
+	case errors.As(failure, &permanent) || attempt >= c.maxAttempts || len(c.retryDelays) <= 0:
+		target = c.queue + deadSuffix
+
+	default:
+		headers[RetryCountHeader] = int32(attempt)
+
+		// the last delay is used for all the attempts after it
+		delay := attempt
+		if delay > len(c.retryDelays) {
+			delay = len(c.retryDelays)
+		}
+
+		target = retryQueue(c.queue, c.retryDelays[delay-1])
+	}
+
+	return target, msg
+}
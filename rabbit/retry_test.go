@@ -0,0 +1,105 @@
+package rabbit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRoute(t *testing.T) {
+
+	c := &Consumer{
+		queue:       "to.youtuber.videos",
+		maxAttempts: 3,
+		retryDelays: []time.Duration{10 * time.Second, time.Minute},
+	}
+
+	failure := errors.New("boom")
+
+	tests := []struct {
+		name       string
+		retries    interface{}
+		failure    error
+		wantTarget string
+		wantCount  int
+	}{
+		{"first failure", nil, failure, "to.youtuber.videos.retry.10s", 1},
+		{"second failure", int32(1), failure, "to.youtuber.videos.retry.1m0s", 2},
+		{"retry count as text", "1", failure, "to.youtuber.videos.retry.1m0s", 2},
+		{"handled max attempts times", int32(2), failure, "to.youtuber.videos.dead", 2},
+		{"permanent error", nil, Permanent(failure), "to.youtuber.videos.dead", 0},
+		{"parked error", int32(1), Park(failure, time.Now().Add(time.Hour)), "to.youtuber.videos.parked", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			d := amqp.Delivery{CorrelationId: "abc", Body: []byte(`{}`), Headers: amqp.Table{}}
+
+			if tt.retries != nil {
+				d.Headers[RetryCountHeader] = tt.retries
+			}
+
+			target, msg := c.route(d, tt.failure)
+
+			if target != tt.wantTarget {
+				t.Errorf("target = %q, want %q", target, tt.wantTarget)
+			}
+
+			if got := RetryCount(amqp.Delivery{Headers: msg.Headers}); got != tt.wantCount {
+				t.Errorf("retry count = %d, want %d", got, tt.wantCount)
+			}
+
+			if msg.Headers[LastErrorHeader] != tt.failure.Error() {
+				t.Errorf("last error = %v, want %q", msg.Headers[LastErrorHeader], tt.failure.Error())
+			}
+
+			if msg.CorrelationId != "abc" || string(msg.Body) != `{}` {
+				t.Error("the message must keep the correlation id and the body")
+			}
+		})
+	}
+}
+
+func TestRouteParkedExpiration(t *testing.T) {
+
+	c := &Consumer{queue: "q", maxAttempts: 3, retryDelays: []time.Duration{time.Second}}
+
+	tests := []struct {
+		name  string
+		until time.Time
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"in one hour", time.Now().Add(time.Hour), 59 * time.Minute, time.Hour},
+		{"already passed", time.Now().Add(-time.Hour), time.Second, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			_, msg := c.route(amqp.Delivery{}, Park(errors.New("no quota"), tt.until))
+
+			ms, err := time.ParseDuration(msg.Expiration + "ms")
+
+			if err != nil {
+				t.Fatalf("expiration %q is not a number of milliseconds", msg.Expiration)
+			}
+
+			if ms < tt.min || ms > tt.max {
+				t.Errorf("expiration = %v, want between %v and %v", ms, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestRouteWithoutDelays(t *testing.T) {
+
+	c := &Consumer{queue: "q", maxAttempts: 5}
+
+	if target, _ := c.route(amqp.Delivery{}, errors.New("boom")); target != "q.dead" {
+		t.Errorf("target = %q, want the dead queue when there are no retry delays", target)
+	}
+}
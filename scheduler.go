@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"soliveboa/youtuber/v2/entities"
+	"soliveboa/youtuber/v2/rabbit"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Jobs of the daemon, by the name used in the settings
+const (
+	jobSyncChannels = "syncChannels"
+	jobPlaylists    = "playlists"
+	jobChannels     = "channels"
+	jobCategories   = "categories"
+	jobLocations    = "locations"
+)
+
+// daemonJob - a job run by the daemon on its schedule
+type daemonJob struct {
+	name     string
+	run      func(ctx context.Context)
+	schedule entities.ScheduleSettings
+}
+
+// daemonJobs returns all the jobs that can be scheduled with their schedules from the settings.
+// Each keyword query is a job of its own, named "query:<name>"
+func daemonJobs() []daemonJob {
+
+	schedules := entities.GetDaemonSettings().Jobs
+
+	jobs := []daemonJob{
+		{name: jobSyncChannels, run: channelsWebServer, schedule: schedules[jobSyncChannels]},
+		{name: jobPlaylists, run: startPlaylist, schedule: schedules[jobPlaylists]},
+		{name: jobChannels, run: channelsSearch, schedule: schedules[jobChannels]},
+		{name: jobCategories, run: startSearcher, schedule: schedules[jobCategories]},
+		{name: jobLocations, run: startLocations, schedule: schedules[jobLocations]},
+	}
+
+	for _, val := range entities.GetQueries() {
+
+		query := val
+
+		jobs = append(jobs, daemonJob{
+			name: "query:" + query.Name,
+			run: func(ctx context.Context) {
+				runQueries(ctx, []entities.Query{query})
+			},
+			schedule: query.Schedule,
+		})
+	}
+
+	return jobs
+}
+
+// daemon - the scheduler of the jobs. The jobs are scheduled again every time the settings are reloaded
+type daemon struct {
+	ctx       context.Context
+	scheduler *cron.Cron
+	mu        sync.Mutex
+	entries   []cron.EntryID
+	running   map[string]*int32
+}
+
+// runDaemon runs the jobs on their schedules and the video consumer alongside them, until the
+// context is cancelled. A job is skipped while its previous run hasn't finished yet
+func runDaemon(ctx context.Context) {
+
+	d := &daemon{
+		ctx:       ctx,
+		scheduler: cron.New(cron.WithChain(cron.Recover(cronLogger{}))),
+		running:   map[string]*int32{},
+	}
+
+	d.schedule()
+	onSettingsReload(d.schedule)
+
+	d.scheduler.Start()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		logFrom(ctx).Info("[  *  ] Processing all the videos from the queue ...")
+		consumeVideo(ctx, 0, 0)
+	}()
+
+	<-ctx.Done()
+
+	// no new runs are started, the running ones stop on the cancelled context
+	<-d.scheduler.Stop().Done()
+	wg.Wait()
+}
+
+// schedule replaces the scheduled jobs by the ones from the settings in use.
+// The runs in progress are not interrupted
+func (d *daemon) schedule() {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, id := range d.entries {
+		d.scheduler.Remove(id)
+	}
+
+	d.entries = nil
+
+	for _, job := range daemonJobs() {
+
+		if job.schedule == (entities.ScheduleSettings{}) {
+			logrus.WithFields(logrus.Fields{
+				"job": job.name,
+			}).Info("Job not scheduled")
+
+			continue
+		}
+
+		schedule, err := parseSchedule(job.schedule)
+
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"job": job.name,
+				"err": err.Error(),
+			}).Error("Invalid job schedule, the job will not run")
+
+			continue
+		}
+
+		j := job
+
+		if d.running[j.name] == nil {
+			d.running[j.name] = new(int32)
+		}
+
+		id := d.scheduler.Schedule(schedule, cron.FuncJob(func() {
+			d.run(j)
+		}))
+
+		d.entries = append(d.entries, id)
+
+		logrus.WithFields(logrus.Fields{
+			"job":  job.name,
+			"next": schedule.Next(time.Now()).Format(time.RFC3339),
+		}).Info("[  *  ] Job scheduled")
+	}
+}
+
+// run skips the job while its previous run hasn't finished yet, even when it was
+// scheduled before a reload
+func (d *daemon) run(job daemonJob) {
+
+	d.mu.Lock()
+	running := d.running[job.name]
+	d.mu.Unlock()
+
+	if !atomic.CompareAndSwapInt32(running, 0, 1) {
+		logrus.WithFields(logrus.Fields{
+			"job": job.name,
+		}).Warning("Job skipped, the previous run is still running")
+
+		return
+	}
+
+	defer atomic.StoreInt32(running, 0)
+
+	runJob(d.ctx, job)
+}
+
+// runJob runs the job once, unless the service is shutting down
+func runJob(ctx context.Context, job daemonJob) {
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	start := time.Now()
+
+	jobRunID := uuid.New().String()
+
+	// all the logs of this run have the job and the id of the run,
+	// which is also the correlation id of the messages published by it
+	ctx = withLog(ctx, logrus.Fields{
+		"job":        job.name,
+		"job_run_id": jobRunID,
+	})
+	ctx = rabbit.WithCorrelationID(ctx, jobRunID)
+
+	logFrom(ctx).Info("[<] Job started")
+
+	job.run(ctx)
+
+	logFrom(ctx).WithFields(logrus.Fields{
+		"duration": time.Since(start).String(),
+	}).Info("[>] Job finished")
+}
+
+// parseSchedule reads the interval ("30m") or the cron expression ("0 */2 * * *") of the job
+func parseSchedule(settings entities.ScheduleSettings) (cron.Schedule, error) {
+
+	if settings.Cron != "" && settings.Interval != "" {
+		return nil, errors.New("use either interval or cron, not both")
+	}
+
+	if settings.Cron != "" {
+		return cron.ParseStandard(settings.Cron)
+	}
+
+	interval, err := time.ParseDuration(settings.Interval)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if interval < time.Minute {
+		return nil, errors.New("the interval must be at least 1m")
+	}
+
+	return cron.Every(interval), nil
+}
+
+// cronLogger sends the scheduler messages (panics) to logrus
+type cronLogger struct{}
+
+func (l cronLogger) Info(msg string, keysAndValues ...interface{}) {
+	logrus.WithFields(l.fields(keysAndValues)).Info("Scheduler: " + msg)
+}
+
+func (l cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	logrus.WithFields(l.fields(keysAndValues)).WithField("err", err).Error("Scheduler: " + msg)
+}
+
+func (l cronLogger) fields(keysAndValues []interface{}) logrus.Fields {
+
+	fields := logrus.Fields{}
+
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if key, ok := keysAndValues[i].(string); ok {
+			fields[key] = keysAndValues[i+1]
+		}
+	}
+
+	return fields
+}
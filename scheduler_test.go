@@ -0,0 +1,46 @@
+package main
+
+import (
+	"soliveboa/youtuber/v2/entities"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+
+	from := time.Date(2026, 10, 18, 10, 7, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		settings entities.ScheduleSettings
+		wantNext time.Time
+		wantErr  bool
+	}{
+		{"interval", entities.ScheduleSettings{Interval: "30m"}, from.Add(30 * time.Minute), false},
+		{"cron", entities.ScheduleSettings{Cron: "0 */2 * * *"}, time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC), false},
+		{"cron every 15 minutes", entities.ScheduleSettings{Cron: "*/15 * * * *"}, time.Date(2026, 10, 18, 10, 15, 0, 0, time.UTC), false},
+		{"interval too short", entities.ScheduleSettings{Interval: "30s"}, time.Time{}, true},
+		{"interval not a duration", entities.ScheduleSettings{Interval: "hourly"}, time.Time{}, true},
+		{"bad cron", entities.ScheduleSettings{Cron: "every day"}, time.Time{}, true},
+		{"interval and cron", entities.ScheduleSettings{Interval: "1h", Cron: "0 * * * *"}, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			schedule, err := parseSchedule(tt.settings)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSchedule(%+v) error = %v, want error %v", tt.settings, err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got := schedule.Next(from); !got.Equal(tt.wantNext) {
+				t.Errorf("Next(%v) = %v, want %v", from, got, tt.wantNext)
+			}
+		})
+	}
+}
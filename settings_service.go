@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"soliveboa/youtuber/v2/entities"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// settingsPollInterval - how often the settings file is checked for changes
+const settingsPollInterval = 10 * time.Second
+
+var (
+	reloadMu    sync.Mutex
+	reloadHooks []func()
+)
+
+// onSettingsReload registers a function called after each reload of the settings
+func onSettingsReload(hook func()) {
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	reloadHooks = append(reloadHooks, hook)
+}
+
+// watchSettings reloads the settings on SIGHUP or when the file changes, until the context is done.
+// The jobs read the new settings on their next run
+func watchSettings(ctx context.Context, path string) {
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(settingsPollInterval)
+	defer ticker.Stop()
+
+	modified := modTime(path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-hup:
+			modified = modTime(path)
+			reloadSettings(path, "SIGHUP")
+
+		case <-ticker.C:
+			m := modTime(path)
+
+			if m.IsZero() || m.Equal(modified) {
+				continue
+			}
+
+			modified = m
+			reloadSettings(path, "file changed")
+		}
+	}
+}
+
+// reloadSettings replaces the settings in use by the ones from the file.
+// Invalid settings are rejected and the ones in use are kept
+func reloadSettings(path string, reason string) {
+
+	old := entities.Current()
+
+	err := entities.Load(path)
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"err":    err.Error(),
+			"file":   path,
+			"reason": reason,
+		}).Error("New settings rejected, keeping the ones in use")
+
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"file":   path,
+		"reason": reason,
+	}).Info("[~] Settings reloaded")
+
+	warnRestartNeeded(old, entities.Current())
+
+	reloadMu.Lock()
+	hooks := append([]func(){}, reloadHooks...)
+	reloadMu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+// warnRestartNeeded reports the changes that are only used when the service starts
+func warnRestartNeeded(old entities.Settings, updated entities.Settings) {
+
+	// the log level is changed on the reload, the format and the output are not
+	oldLog, updatedLog := old.Log, updated.Log
+	oldLog.Level, updatedLog.Level = "", ""
+
+	sections := map[string][2]interface{}{
+		"env":      {old.Env, updated.Env},
+		"auth":     {old.Auth, updated.Auth},
+		"rabbit":   {old.Rabbit, updated.Rabbit},
+		"database": {old.Database, updated.Database},
+		"consumer": {old.Consumer, updated.Consumer},
+		"quota":    {old.Quota, updated.Quota},
+		"log":      {oldLog, updatedLog},
+	}
+
+	for name, values := range sections {
+		if !reflect.DeepEqual(values[0], values[1]) {
+			logrus.WithFields(logrus.Fields{
+				"section": name,
+			}).Warning("The changes on this section are only used after a restart")
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
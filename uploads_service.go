@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"soliveboa/youtuber/v2/dao"
+	"soliveboa/youtuber/v2/entities"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+// errStopPaging is returned from the page callback to stop reading the playlist
+var errStopPaging = errors.New("stop paging")
+
+// ResolveUploadsPlaylists method
+// Gets the uploads playlist of the channels that don't have it cached yet, 50 channels per call
+func (s ChannelWebListService) ResolveUploadsPlaylists(ctx context.Context, key string, channels []dao.Channel) error {
+
+	pending := map[string]int{}
+	var ids []string
+
+	for i := range channels {
+		if channels[i].UploadsPlaylistID == "" {
+			pending[channels[i].ChannelID] = i
+			ids = append(ids, channels[i].ChannelID)
+		}
+	}
+
+	if len(ids) <= 0 {
+		return nil
+	}
+
+	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(key))
+
+	if err != nil {
+		return err
+	}
+
+	channelService := repositories.Channels
+
+	for start := 0; start < len(ids); start += 50 {
+
+		end := start + 50
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		call := youtubeService.Channels.List("contentDetails")
+		call.Id(strings.Join(ids[start:end], ","))
+		call.MaxResults(50)
+		call.Fields("items(id,contentDetails(relatedPlaylists(uploads)))")
+
+		response, err := call.Context(ctx).Do()
+
+		if err != nil {
+			return err
+		}
+
+		keyPool.Charge(key, dao.QuotaCostChannels)
+
+		for _, item := range response.Items {
+
+			if item.ContentDetails == nil || item.ContentDetails.RelatedPlaylists == nil {
+				continue
+			}
+
+			uploads := item.ContentDetails.RelatedPlaylists.Uploads
+
+			if uploads == "" {
+				continue
+			}
+
+			channelService.UpdateUploadsPlaylist(item.Id, uploads)
+			channels[pending[item.Id]].UploadsPlaylistID = uploads
+		}
+	}
+
+	return nil
+}
+
+// RetrieveUploads method
+// Reads the uploads playlist of the channel, newest first, until the videos are older than the
+// incremental window. Each page costs 1 unit against the 100 of the search.
+// There is no checkpoint here: the crawl always starts from the newest videos
+func (s ChannelWebListService) RetrieveUploads(ctx context.Context, key string, channel dao.Channel) error {
+
+	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(key))
+
+	if err != nil {
+		return err
+	}
+
+	publishedAfter := time.Now().Add(-entities.GetChannelCrawlParameters().IncrementalWindow())
+
+	call := youtubeService.PlaylistItems.List("contentDetails")
+	call.PlaylistId(channel.UploadsPlaylistID)
+	call.MaxResults(50)
+	call.Fields("nextPageToken,items(contentDetails(videoId,videoPublishedAt))")
+
+	total := 0
+
+	err = call.Pages(ctx, func(values *youtube.PlaylistItemListResponse) error {
+		keyPool.Charge(key, dao.QuotaCostPlaylistItems)
+
+		var id []string
+		stop := false
+
+		for _, item := range values.Items {
+
+			if item.ContentDetails == nil || item.ContentDetails.VideoId == "" {
+				continue
+			}
+
+			// the playlist is sorted by the newest, all the next ones are older
+			published, err := time.Parse(time.RFC3339, item.ContentDetails.VideoPublishedAt)
+
+			if err == nil && published.Before(publishedAfter) {
+				stop = true
+				break
+			}
+
+			id = append(id, item.ContentDetails.VideoId)
+		}
+
+		if len(id) > 0 {
+
+			err := sendResponse(ctx, &ListOfIdsFromSearch{Source: "channel", IDs: id})
+
+			if err != nil {
+				return err
+			}
+
+			total += len(id)
+		}
+
+		if stop {
+			return errStopPaging
+		}
+
+		return nil
+	})
+
+	if err != nil && err != errStopPaging {
+		return err
+	}
+
+	logFrom(ctx).WithFields(logrus.Fields{
+		"channel_id": channel.ChannelID,
+		"total":      total,
+	}).Info("[>] Finished channel uploads playlist")
+
+	return nil
+}
@@ -4,21 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"flag"
-	"fmt"
 	"soliveboa/youtuber/v2/dao"
 	"soliveboa/youtuber/v2/entities"
 	_errors "soliveboa/youtuber/v2/errors"
-	"soliveboa/youtuber/v2/rabbit"
-	"time"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 )
 
-var apiKey string
-
 // MessageResponseVideo from youtuber
 type MessageResponseVideo struct {
 	Source string                    `json:"source"`
@@ -30,9 +25,11 @@ type Youtube struct {
 }
 
 // ListOfIdsFromSearch struct
+// Location is the name of the location the ids were found, only for the location searches
 type ListOfIdsFromSearch struct {
-	Source string   `json:"source"`
-	IDs    []string `json:"ids"`
+	Source   string   `json:"source"`
+	Location string   `json:"location,omitempty"`
+	IDs      []string `json:"ids"`
 }
 
 // NewYotubeService - creates a new instance
@@ -40,20 +37,16 @@ func NewYotubeService() Youtube {
 	return Youtube{}
 }
 
-var totalAlreadyProcessed = 0
-var categoryID = ""
-var totalNull = 0
-
-// RunByPlaylist search by playlist ID
-func (y Youtube) RunByPlaylist(k string, playlistID string) error {
-
-	flag.Parse()
+// errNullPages (AP001) - the search has returned 5 empty pages in a row, there is nothing more to read
+var errNullPages = errors.New("AP001 - Reached null api response")
 
-	apiKey = k
+// totalAlreadyProcessed - pages processed by all the crawls, only used by the logs
+var totalAlreadyProcessed int64
 
-	ctx := context.Background()
+// RunByPlaylist search by playlist ID
+func (y Youtube) RunByPlaylist(ctx context.Context, k string, playlistID string) error {
 
-	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(apiKey))
+	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(k))
 
 	if err != nil {
 		return err
@@ -63,26 +56,35 @@ func (y Youtube) RunByPlaylist(k string, playlistID string) error {
 	call.PlaylistId(playlistID)
 	call.MaxResults(50)
 
-	err = call.Pages(ctx, addPlaylistPaginedResults)
+	// resume from the page where the last run has stopped
+	crawlKey := "playlist:" + playlistID
+	call.PageToken(loadCheckpoint(crawlKey))
 
-	_errors.HandleError("Error call.Pages() playlist", err, false)
+	// empty pages in a row, each crawl has its own count
+	nulls := 0
 
-	return err
+	err = call.Pages(ctx, func(values *youtube.PlaylistItemListResponse) error {
+		keyPool.Charge(k, dao.QuotaCostPlaylistItems)
 
-}
-
-func addPlaylistPaginedResults(values *youtube.PlaylistItemListResponse) error {
+		err := addPlaylistPaginedResults(ctx, values, &nulls)
 
-	totalAlreadyProcessed++
+		if err != nil {
+			return err
+		}
 
-	if len(values.Items) < 0 {
-		logrus.Warn("The message receive from API doesnt't have any item: Playlist!!")
+		saveCheckpoint(crawlKey, values.NextPageToken)
 		return nil
-	}
+	})
+
+	_errors.HandleError("Error call.Pages() playlist", err, false)
 
-	//define tokens
-	// var nextToken = values.NextPageToken
-	//var prev = values.PrevPageToken
+	return checkpointError(crawlKey, err)
+
+}
+
+func addPlaylistPaginedResults(ctx context.Context, values *youtube.PlaylistItemListResponse, totalNull *int) error {
+
+	processed := atomic.AddInt64(&totalAlreadyProcessed, 1)
 
 	// define id array
 	var id []string
@@ -93,7 +95,8 @@ func addPlaylistPaginedResults(values *youtube.PlaylistItemListResponse) error {
 		vid := values.Items[key].Snippet.ResourceId.VideoId
 
 		if vid == "" {
-			logrus.Warning("ATTENTION: the video ID is null")
+			logFrom(ctx).Warning("ATTENTION: the video ID is null")
+			continue
 		}
 
 		id = append(id, vid)
@@ -106,61 +109,43 @@ func addPlaylistPaginedResults(values *youtube.PlaylistItemListResponse) error {
 	if len(listID.IDs) <= 0 {
 
 		// increment
-		totalNull++
-		fmt.Printf("null count: %v\n", totalNull)
-
-		// tokenRec := dao.NewTokenRecoveryService()
-		// b := dao.TokenRecovery{NextToken: nextToken}
-		// tokenRec.Insert(b)
+		*totalNull++
+		logFrom(ctx).WithFields(logrus.Fields{
+			"nulls": *totalNull,
+		}).Debug("Empty page from the api")
 
 		// is more than 5
-		if totalNull >= 5 {
-			return errors.New("AP001 - Reached null api response")
+		if *totalNull >= 5 {
+			return errNullPages
 		}
 
 		return nil
 	}
 
 	// reset count null var because the last one was not empty
-	totalNull = 0
+	*totalNull = 0
 
-	// send the message to rabbit
-	err := sendResponse(listID)
+	// send the message to rabbit. A page not published is not checkpointed, so it is read again
+	err := sendResponse(ctx, listID)
 
 	if err != nil {
-
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Error("Error to send message to queue")
-
+		return err
 	}
 
-	logrus.WithFields(logrus.Fields{
-		"Proccessed": totalAlreadyProcessed,
+	logFrom(ctx).WithFields(logrus.Fields{
+		"processed": processed,
 	}).Info(" [~] Total of videos proccessed ...")
 
 	return nil
 
 }
 
-// RunService - retrieve the videos from search list
-func (y Youtube) RunService(k string, videoCategory string) error {
-
-	totalNull = 0
-
-	// set the keys
-	apiKey = k
-	categoryID = videoCategory
-
-	logrus.WithFields(logrus.Fields{
-		"Category": categoryID,
-	}).Info("[<] Started pages from search list")
-
-	flag.Parse()
+// RunService - retrieve the videos of the category from search list.
+// The messages have the source "category:<id>" and each category has its own checkpoint.
+// The crawl ends without error when the search only returns empty pages (AP001)
+func (y Youtube) RunService(ctx context.Context, k string, videoCategory string) error {
 
-	ctx := context.Background()
-
-	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(apiKey))
+	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(k))
 
 	if err != nil {
 		return err
@@ -168,11 +153,6 @@ func (y Youtube) RunService(k string, videoCategory string) error {
 
 	// get parameters list
 	pl := entities.GetParametersList()
-	publishedAfter := time.Now().AddDate(0, 0, -1).Format(time.RFC3339)
-
-	if pl.PublishedAfter != "" {
-		publishedAfter = pl.PublishedAfter
-	}
 
 	// create the call actions
 	call := youtubeService.Search.List(pl.Part)
@@ -181,158 +161,163 @@ func (y Youtube) RunService(k string, videoCategory string) error {
 	call.EventType(pl.EventType)
 	call.MaxResults(pl.MaxResults)
 	call.RelevanceLanguage(pl.Language)
-	call.PublishedAfter(publishedAfter)
+	call.PublishedAfter(pl.SearchPublishedAfter())
 	call.Order(pl.Order)
 	call.VideoCategoryId(videoCategory)
-	call.Fields("prevPageToken,nextPageToken,items(id(videoId),snippet(channelId))")
 
-	// run paged result
-	err = call.Pages(ctx, addPaginedResults)
+	source := "category:" + videoCategory
+	err = runSearch(ctx, k, call, source, "")
 
-	if err != nil {
-		return err
+	// only empty pages from here on: the category is done, the next run starts from the first page
+	if err == errNullPages {
+		logFrom(ctx).WithFields(logrus.Fields{
+			"source": source,
+		}).Info("[>] No more results on the category (AP001)")
+
+		return nil
 	}
 
-	// before move on , we delete the remaining data on the search control collection. It means that all the page were looked up properly
+	return err
+}
 
-	logrus.WithFields(logrus.Fields{
-		"Category": categoryID,
-	}).Info("[>] Finished pages from search list")
+// runSearch reads all the pages of the search call, resuming from the checkpoint of the source.
+// Every page is published with the source and the location of the search
+func runSearch(ctx context.Context, k string, call *youtube.SearchListCall, source string, location string) error {
 
-	return nil
+	logFrom(ctx).WithFields(logrus.Fields{
+		"source": source,
+	}).Info("[<] Started pages from search list")
 
-}
+	call.Fields("prevPageToken,nextPageToken,items(id(videoId),snippet(channelId))")
+
+	// resume from the page where the last run has stopped
+	call.PageToken(loadCheckpoint(source))
 
-func addPaginedResults(values *youtube.SearchListResponse) error {
+	// empty pages in a row, each crawl has its own count
+	nulls := 0
 
-	totalAlreadyProcessed++
+	err := call.Pages(ctx, func(values *youtube.SearchListResponse) error {
+		keyPool.Charge(k, dao.QuotaCostSearch)
+
+		err := addSearchPagedResult(ctx, values, &nulls, source, location)
+
+		if err != nil {
+			return err
+		}
 
-	if len(values.Items) < 0 {
-		logrus.Warn("The message receive from API doesnt't have any item")
+		saveCheckpoint(source, values.NextPageToken)
 		return nil
+	})
+
+	if err != nil {
+		return checkpointError(source, err)
 	}
 
+	logFrom(ctx).WithFields(logrus.Fields{
+		"source": source,
+	}).Info("[>] Finished pages from search list")
+
+	return nil
+}
+
+// addSearchPagedResult publishes the ids of the page with the source (and location) of the search.
+// Returns the AP001 error after 5 empty pages in a row
+func addSearchPagedResult(ctx context.Context, values *youtube.SearchListResponse, totalNull *int, source string, location string) error {
+
+	processed := atomic.AddInt64(&totalAlreadyProcessed, 1)
+
 	// define id array
 	var id []string
 
-	// looping through the items
 	for key := range values.Items {
 
 		vid := values.Items[key].Id.VideoId
 
 		if vid == "" {
-			logrus.Warning("ATTENTION: the video ID is null")
+			logFrom(ctx).Warning("ATTENTION: the video ID is null")
+			continue
+		}
+
+		if values.Items[key].Snippet != nil && skipBlacklisted(ctx, vid, values.Items[key].Snippet.ChannelId, source) {
+			continue
 		}
 
 		id = append(id, vid)
 	}
 
-	// define the list
-	listID := &ListOfIdsFromSearch{IDs: id}
-
 	// check if the return is null
-	if len(listID.IDs) <= 0 {
+	if len(id) <= 0 {
 
-		// increment
-		totalNull++
-		fmt.Printf("null count: %v\n", totalNull)
+		*totalNull++
+		logFrom(ctx).WithFields(logrus.Fields{
+			"nulls": *totalNull,
+		}).Debug("Empty page from the api")
 
-		// is more than 5
-		if totalNull >= 5 {
-			return errors.New("AP001 - Reached null api response")
+		if *totalNull >= 5 {
+			return errNullPages
 		}
 
 		return nil
 	}
 
 	// reset count null var because the last one was not empty
-	totalNull = 0
+	*totalNull = 0
 
-	// send the message to rabbit
-	err := sendResponse(listID)
+	// every message tells the search it came from
+	err := sendResponse(ctx, &ListOfIdsFromSearch{Source: source, Location: location, IDs: id})
 
 	if err != nil {
-
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Error("Error to send message to queue")
-
+		return err
 	}
 
-	logrus.WithFields(logrus.Fields{
-		"Proccessed": totalAlreadyProcessed,
+	logFrom(ctx).WithFields(logrus.Fields{
+		"processed": processed,
+		"source":    source,
 	}).Info(" [~] Total of list proccessed ...")
 
 	return nil
 }
 
-func sendResponse(a *ListOfIdsFromSearch) error {
+func sendResponse(ctx context.Context, a *ListOfIdsFromSearch) error {
 
 	v, err := json.Marshal(a)
 
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
+		logFrom(ctx).WithFields(logrus.Fields{
+			"err": err,
 		}).Error("Error to serialize video list")
 
 		return err
 	}
 
-	service := rabbit.New()
-	conn, err := service.Connect()
-
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Error("Error to connect to the broker")
-
-		return err
-	}
-
-	exchange, err := conn.Exchange("to.youtuber.videos")
-
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error":    err,
-			"exchange": "to.youtuber.videos",
-		}).Error("Error to declare exchange")
-
-		return err
-	}
-
-	_, err = exchange.Publish(v)
+	err = broker.Publish(ctx, "to.youtuber.videos", v)
 
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err,
+		logFrom(ctx).WithFields(logrus.Fields{
+			"err": err,
 		}).Error("Error to publish the message")
 
 		return err
 	}
 
-	logrus.Info("Page has been sent to queue")
+	logFrom(ctx).Info("Page has been sent to queue")
 
 	return nil
 
 }
 
 // SearchVideoByID - list video details by ID
-func (y Youtube) SearchVideoByID(source string, videoID string, k string) error {
+// It runs on many consumer workers at once, so it only uses the key received
+func (y Youtube) SearchVideoByID(ctx context.Context, source string, videoID string, k string) error {
 
-	apiKey = k
-
-	flag.Parse()
-
-	ctx := context.Background()
-
-	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(apiKey))
+	youtubeService, err := youtube.NewService(ctx, option.WithAPIKey(k))
 
 	if err != nil {
 		// define the which kind of error
-		logrus.WithFields(logrus.Fields{
-			"ids":    videoID,
-			"action": "video",
-			"err":    err.Error(),
+		logFrom(ctx).WithFields(logrus.Fields{
+			"video_id": videoID,
+			"action":   "video",
+			"err":      err.Error(),
 		}).Error("Erro to connect to youtube service - video")
 
 		return err
@@ -345,12 +330,17 @@ func (y Youtube) SearchVideoByID(source string, videoID string, k string) error
 	call.MaxResults(50)
 	call.Fields("items(id,snippet(publishedAt,channelId,title,description,thumbnails,channelTitle,categoryId,liveBroadcastContent),statistics,player,liveStreamingDetails)")
 
-	response, err := call.Do()
+	response, err := call.Context(ctx).Do()
 
 	if err != nil {
 		return err
 	}
 
+	keyPool.Charge(k, dao.QuotaCostVideos)
+
+	// the videos not confirmed by the broker are reported, so the message can be tried again
+	var publishErr error
+
 	for key := range response.Items {
 
 		if response.Items[key].Id != "" {
@@ -364,72 +354,60 @@ func (y Youtube) SearchVideoByID(source string, videoID string, k string) error
 				Videos: v,
 			}
 
-			_ = y.ProcessVideo(message)
+			if err := y.ProcessVideo(ctx, message); err != nil {
+				publishErr = err
+			}
 		}
 	}
 
 	//return *response, nil
-	return nil
+	return publishErr
 
 }
 
 // ProcessVideo - method
-func (y Youtube) ProcessVideo(r *MessageResponseVideo) error {
+// The video is published with the correlation id of the context, the one of the message received
+func (y Youtube) ProcessVideo(ctx context.Context, r *MessageResponseVideo) error {
 
-	v, err := json.Marshal(r)
+	// videos from blacklisted channels never reach the processor
+	if len(r.Videos.Items) > 0 && r.Videos.Items[0].Snippet != nil {
+		item := r.Videos.Items[0]
 
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error":  err,
-			"action": "video",
-		}).Error("Error to serialize video items")
-
-		return err
+		if skipBlacklisted(ctx, item.Id, item.Snippet.ChannelId, r.Source) {
+			return nil
+		}
 	}
 
-	service := rabbit.New()
-	conn, err := service.Connect()
+	v, err := json.Marshal(r)
 
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error":  err,
+		logFrom(ctx).WithFields(logrus.Fields{
+			"err":    err,
 			"action": "video",
-		}).Error("Error to connect to the broker")
-
-		return err
-	}
-
-	exchange, err := conn.Exchange("to.processor.post")
-
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error":    err,
-			"action":   "video",
-			"exchange": "to.processor.post",
-		}).Error("Error to declare exchange")
+		}).Error("Error to serialize video items")
 
 		return err
 	}
 
-	_, err = exchange.Publish(v)
+	err = broker.Publish(ctx, "to.processor.post", v)
 
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error":  err,
+		logFrom(ctx).WithFields(logrus.Fields{
+			"err":    err,
 			"action": "video",
 		}).Error("Error to publish the message")
 
 		return err
 	}
 
-	// salva no banco de dados o ID
-	videoService := dao.NewVideosService()
+	// salva no banco de dados o ID, somente depois da confirmação do broker
+	videoService := repositories.Videos
 	video := dao.Videos{VideoID: r.Videos.Items[0].Id, ChannelID: r.Videos.Items[0].Snippet.ChannelId}
 	videoService.Insert(video)
 
-	logrus.WithFields(logrus.Fields{
-		"id":     r.Videos.Items[0].Id,
-		"action": "video",
+	logFrom(ctx).WithFields(logrus.Fields{
+		"video_id": r.Videos.Items[0].Id,
+		"action":   "video",
 	}).Info("Page has been sent to queue")
 
 	return nil